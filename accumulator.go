@@ -0,0 +1,129 @@
+package xorkv
+
+import (
+	"encoding"
+	"errors"
+)
+
+// ErrInvalidAccumulatorState is returned when accumulator state can't be
+// deserialized.
+var ErrInvalidAccumulatorState = errors.New("invalid accumulator state")
+
+// Accumulator is a homomorphic set hash used to calculate store checksums.
+// Elements can be added and removed in any order and the resulting digest
+// only depends on the set of elements, which allows to update the checksum
+// incrementally with every store change.
+type Accumulator interface {
+	// Add adds an element to the set.
+	Add(e Uint256)
+	// Remove removes an element (previously added) from the set.
+	Remove(e Uint256)
+	// Combine adds all elements of the other accumulator to this one, both
+	// accumulators must be of the same kind.
+	Combine(o Accumulator)
+	// Sum finalizes the accumulator and returns the digest of the set, its
+	// size depends on the accumulator kind.
+	Sum() []byte
+	// Zero returns a new empty accumulator of the same kind.
+	Zero() Accumulator
+	// Kind returns accumulator kind identifier.
+	Kind() AccumulatorKind
+
+	encoding.BinaryMarshaler
+	encoding.BinaryUnmarshaler
+}
+
+// AccumulatorKind identifies accumulator algorithm in checksums.
+type AccumulatorKind byte
+
+// AccumulatorKind values, they're recorded in checksums, so they can't be
+// changed.
+const (
+	KindXor    AccumulatorKind = 0
+	KindLtHash AccumulatorKind = 1
+	KindMuHash AccumulatorKind = 2
+	KindECMH   AccumulatorKind = 3
+)
+
+// String implements the fmt.Stringer interface.
+func (k AccumulatorKind) String() string {
+	switch k {
+	case KindXor:
+		return "xor"
+	case KindLtHash:
+		return "lthash"
+	case KindMuHash:
+		return "muhash"
+	case KindECMH:
+		return "ecmh"
+	default:
+		return "unknown"
+	}
+}
+
+// parseAccumulatorKind returns AccumulatorKind by its String representation.
+func parseAccumulatorKind(s string) (AccumulatorKind, error) {
+	for _, k := range []AccumulatorKind{KindXor, KindLtHash, KindMuHash, KindECMH} {
+		if k.String() == s {
+			return k, nil
+		}
+	}
+	return 0, ErrInvalidChecksum
+}
+
+// XorAccumulator is an Accumulator XORing element hashes, it's the default
+// one used by stores.
+type XorAccumulator Uint256
+
+// Add implements the Accumulator interface.
+func (a *XorAccumulator) Add(e Uint256) {
+	(*Uint256)(a).Xor(e)
+}
+
+// Remove implements the Accumulator interface.
+func (a *XorAccumulator) Remove(e Uint256) {
+	(*Uint256)(a).Xor(e)
+}
+
+// Combine implements the Accumulator interface.
+func (a *XorAccumulator) Combine(o Accumulator) {
+	(*Uint256)(a).Xor(Uint256(*o.(*XorAccumulator)))
+}
+
+// Sum implements the Accumulator interface.
+func (a *XorAccumulator) Sum() []byte {
+	res := make([]byte, len(a))
+	copy(res, a[:])
+	return res
+}
+
+// Zero implements the Accumulator interface.
+func (a *XorAccumulator) Zero() Accumulator {
+	return new(XorAccumulator)
+}
+
+// Kind implements the Accumulator interface.
+func (a *XorAccumulator) Kind() AccumulatorKind {
+	return KindXor
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (a *XorAccumulator) MarshalBinary() ([]byte, error) {
+	return a.Sum(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (a *XorAccumulator) UnmarshalBinary(data []byte) error {
+	if len(data) != len(a) {
+		return ErrInvalidAccumulatorState
+	}
+	copy(a[:], data)
+	return nil
+}
+
+// cloneAccumulator returns a copy of the given accumulator.
+func cloneAccumulator(a Accumulator) Accumulator {
+	c := a.Zero()
+	c.Combine(a)
+	return c
+}
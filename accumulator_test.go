@@ -0,0 +1,241 @@
+package xorkv
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type accSetup struct {
+	name   string
+	create func() Accumulator
+}
+
+type accTestFunction func(*testing.T, Accumulator)
+
+// testElements returns a set of distinct elements to feed into accumulators.
+func testElements(n int) []Uint256 {
+	res := make([]Uint256, n)
+	for i := range res {
+		res[i] = HashKV("key", []byte{byte(i), byte(i >> 8)})
+	}
+	return res
+}
+
+func testAccumulatorOrder(t *testing.T, a Accumulator) {
+	els := testElements(16)
+	b := a.Zero()
+	for i := range els {
+		a.Add(els[i])
+		b.Add(els[len(els)-1-i])
+	}
+	require.Equal(t, a.Sum(), b.Sum())
+}
+
+func testAccumulatorRemove(t *testing.T, a Accumulator) {
+	els := testElements(16)
+	empty := a.Zero().Sum()
+	for _, e := range els {
+		a.Add(e)
+	}
+	half := a.Zero()
+	for _, e := range els[:8] {
+		half.Add(e)
+	}
+	for _, e := range els[8:] {
+		a.Remove(e)
+	}
+	require.Equal(t, half.Sum(), a.Sum())
+	for _, e := range els[:8] {
+		a.Remove(e)
+	}
+	require.Equal(t, empty, a.Sum())
+
+	// Removal before addition is fine too.
+	a.Remove(els[0])
+	a.Add(els[0])
+	require.Equal(t, empty, a.Sum())
+}
+
+func testAccumulatorDistinct(t *testing.T, a Accumulator) {
+	els := testElements(2)
+	b := a.Zero()
+	empty := a.Zero().Sum()
+	a.Add(els[0])
+	b.Add(els[1])
+	require.NotEqual(t, a.Sum(), b.Sum())
+	require.NotEqual(t, empty, a.Sum())
+}
+
+func testAccumulatorCombine(t *testing.T, a Accumulator) {
+	els := testElements(16)
+	b := a.Zero()
+	all := a.Zero()
+	for i, e := range els {
+		if i%2 == 0 {
+			a.Add(e)
+		} else {
+			b.Add(e)
+		}
+		all.Add(e)
+	}
+	a.Combine(b)
+	require.Equal(t, all.Sum(), a.Sum())
+
+	// Combining with removals.
+	delta := a.Zero()
+	delta.Remove(els[0])
+	delta.Add(els[1])
+	all.Remove(els[0])
+	all.Add(els[1])
+	a.Combine(delta)
+	require.Equal(t, all.Sum(), a.Sum())
+}
+
+func testAccumulatorClone(t *testing.T, a Accumulator) {
+	els := testElements(4)
+	a.Add(els[0])
+	c := cloneAccumulator(a)
+	require.Equal(t, a.Sum(), c.Sum())
+	c.Add(els[1])
+	require.NotEqual(t, a.Sum(), c.Sum())
+}
+
+func testAccumulatorMarshal(t *testing.T, a Accumulator) {
+	els := testElements(16)
+	for _, e := range els[:8] {
+		a.Add(e)
+	}
+	for _, e := range els[8:] {
+		a.Remove(e)
+	}
+	data, err := a.MarshalBinary()
+	require.NoError(t, err)
+	b := a.Zero()
+	require.NoError(t, b.UnmarshalBinary(data))
+	require.Equal(t, a.Sum(), b.Sum())
+
+	// Restored accumulator should keep working the same way.
+	for _, e := range els[8:] {
+		a.Add(e)
+		b.Add(e)
+	}
+	require.Equal(t, a.Sum(), b.Sum())
+
+	require.Error(t, b.UnmarshalBinary(data[:len(data)-1]))
+	require.Error(t, b.UnmarshalBinary(nil))
+}
+
+func TestAllAccumulators(t *testing.T) {
+	var accs = []accSetup{
+		{"Xor", func() Accumulator { return new(XorAccumulator) }},
+		{"LtHash", func() Accumulator { return new(LtHashAccumulator) }},
+		{"MuHash", func() Accumulator { return new(MuHashAccumulator) }},
+		{"ECMH", func() Accumulator { return new(ECMHAccumulator) }},
+	}
+	var tests = []accTestFunction{testAccumulatorOrder, testAccumulatorRemove,
+		testAccumulatorDistinct, testAccumulatorCombine, testAccumulatorClone,
+		testAccumulatorMarshal}
+	for _, acc := range accs {
+		for _, test := range tests {
+			a := acc.create()
+			twrapper := func(t *testing.T) {
+				test(t, a)
+			}
+			fname := runtime.FuncForPC(reflect.ValueOf(test).Pointer()).Name()
+			t.Run(acc.name+"/"+fname, twrapper)
+		}
+	}
+}
+
+func TestXorAccumulator(t *testing.T) {
+	els := testElements(2)
+	ref := Uint256{}
+	ref.Xor(els[0])
+	ref.Xor(els[1])
+
+	a := new(XorAccumulator)
+	require.Equal(t, make([]byte, len(ref)), a.Sum())
+	a.Add(els[0])
+	a.Add(els[1])
+	require.Equal(t, ref[:], a.Sum())
+}
+
+// countingAccumulator wraps XorAccumulator counting the number of operations.
+type countingAccumulator struct {
+	XorAccumulator
+	adds, removes *int
+}
+
+func (a *countingAccumulator) Add(e Uint256) {
+	*a.adds++
+	a.XorAccumulator.Add(e)
+}
+
+func (a *countingAccumulator) Remove(e Uint256) {
+	*a.removes++
+	a.XorAccumulator.Remove(e)
+}
+
+func (a *countingAccumulator) Combine(o Accumulator) {
+	a.XorAccumulator.Combine(&o.(*countingAccumulator).XorAccumulator)
+}
+
+func (a *countingAccumulator) Zero() Accumulator {
+	return &countingAccumulator{adds: a.adds, removes: a.removes}
+}
+
+func TestStoresWithAccumulator(t *testing.T) {
+	var adds, removes int
+	acc := &countingAccumulator{adds: &adds, removes: &removes}
+
+	ps := NewMemoryStore(WithAccumulator(acc))
+	s := NewMemCachedStore(ps, WithAccumulator(acc))
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("key"), []byte("newvalue")))
+	require.NoError(t, s.Delete(stKey("key")))
+	// Only the per-prefix sum is updated.
+	require.Equal(t, 2, adds)
+	require.Equal(t, 2, removes)
+
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	_, err := s.Persist()
+	require.NoError(t, err)
+	adds = 0
+	require.Equal(t, ps.Checksum(), s.Checksum())
+	require.Equal(t, 1, adds)
+}
+
+// testStoresAccumulatorConsistency checks that incremental MemCachedStore
+// checksum matches full MemoryStore recomputation for the given accumulator.
+func testStoresAccumulatorConsistency(t *testing.T, acc Accumulator) {
+	ps := NewMemoryStore(WithAccumulator(acc))
+	s := NewMemCachedStore(ps, WithAccumulator(acc))
+	o := newOptions([]Option{WithAccumulator(acc)})
+	empty := o.checksum(acc.Zero())
+
+	require.Equal(t, empty, ps.Checksum())
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	require.NoError(t, s.Put(stKey("bar"), []byte("baz")))
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+
+	require.NoError(t, s.Put(stKey("key"), []byte("newvalue")))
+	require.NoError(t, s.Delete(stKey("foo")))
+	require.NoError(t, s.Put(stKey("qux"), []byte("quux")))
+	require.NoError(t, s.Delete(stKey("qux")))
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+
+	require.NoError(t, s.Delete(stKey("key")))
+	require.NoError(t, s.Delete(stKey("bar")))
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, empty, ps.Checksum())
+	require.Equal(t, empty, s.Checksum())
+}
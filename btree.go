@@ -0,0 +1,397 @@
+package xorkv
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// btreeDegree is the minimum number of children of inner B-tree nodes (except
+// the root), nodes have from btreeDegree-1 to 2*btreeDegree-1 items.
+const btreeDegree = 32
+
+const (
+	btreeMaxItems = 2*btreeDegree - 1
+	btreeMinItems = btreeDegree - 1
+)
+
+// btree is an ordered in-memory B-tree of entries with copy-on-write
+// snapshots: nodes are never changed after a snapshot is taken, they're
+// copied instead, so snapshots can be iterated over without locking.
+type btree struct {
+	root *btreeNode
+	len  int
+	// gen is the generation of nodes that can be changed in place.
+	gen uint64
+	// shared is set by snapshot, it makes the next change increment gen. It's
+	// atomic, so snapshots can be taken concurrently with reads.
+	shared atomic.Bool
+}
+
+// btreeNode is a B-tree node, leaf nodes have no children.
+type btreeNode struct {
+	gen      uint64
+	items    []kvEntry
+	children []*btreeNode
+}
+
+// find returns the index of the first item with the key greater than or
+// equal to the given one and whether it's equal.
+func (n *btreeNode) find(key string) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool { return n.items[i].key >= key })
+	return i, i < len(n.items) && n.items[i].key == key
+}
+
+// snapshot returns the root of the current tree that won't be changed. It can
+// be called concurrently with other read-only methods.
+func (t *btree) snapshot() *btreeNode {
+	t.shared.Store(true)
+	return t.root
+}
+
+// reset removes all entries from the tree.
+func (t *btree) reset() {
+	t.root, t.len = nil, 0
+	t.gen++
+	t.shared.Store(false)
+}
+
+// write prepares the tree for changes making nodes shared with snapshots
+// immutable.
+func (t *btree) write() {
+	if t.shared.Load() {
+		t.gen++
+		t.shared.Store(false)
+	}
+}
+
+// mutable returns the node that can be changed in place, it's a copy of n if
+// n can be a part of some snapshot.
+func (t *btree) mutable(n *btreeNode) *btreeNode {
+	if n.gen == t.gen {
+		return n
+	}
+	c := &btreeNode{gen: t.gen, items: make([]kvEntry, len(n.items), btreeMaxItems)}
+	copy(c.items, n.items)
+	if len(n.children) != 0 {
+		c.children = make([]*btreeNode, len(n.children), btreeMaxItems+1)
+		copy(c.children, n.children)
+	}
+	return c
+}
+
+// mutableChild returns the mutable i-th child of the mutable node n.
+func (t *btree) mutableChild(n *btreeNode, i int) *btreeNode {
+	c := t.mutable(n.children[i])
+	n.children[i] = c
+	return c
+}
+
+// get returns the entry for the key.
+func (t *btree) get(key string) (kvEntry, bool) {
+	for n := t.root; n != nil; {
+		i, found := n.find(key)
+		if found {
+			return n.items[i], true
+		}
+		if len(n.children) == 0 {
+			break
+		}
+		n = n.children[i]
+	}
+	return kvEntry{}, false
+}
+
+// set adds the entry to the tree replacing the one with the same key if
+// present, the old entry is returned then.
+func (t *btree) set(e kvEntry) (kvEntry, bool) {
+	t.write()
+	if t.root == nil {
+		t.root = &btreeNode{gen: t.gen, items: append(make([]kvEntry, 0, btreeMaxItems), e)}
+		t.len++
+		return kvEntry{}, false
+	}
+	t.root = t.mutable(t.root)
+	if len(t.root.items) >= btreeMaxItems {
+		item, second := t.split(t.root, btreeMaxItems/2)
+		root := &btreeNode{
+			gen:      t.gen,
+			items:    append(make([]kvEntry, 0, btreeMaxItems), item),
+			children: append(make([]*btreeNode, 0, btreeMaxItems+1), t.root, second),
+		}
+		t.root = root
+	}
+	old, replaced := t.insert(t.root, e)
+	if !replaced {
+		t.len++
+	}
+	return old, replaced
+}
+
+// split splits the mutable node n at the i-th item returning this item and
+// the new node with items (and children) after it.
+func (t *btree) split(n *btreeNode, i int) (kvEntry, *btreeNode) {
+	item := n.items[i]
+	next := &btreeNode{gen: t.gen, items: make([]kvEntry, 0, btreeMaxItems)}
+	next.items = append(next.items, n.items[i+1:]...)
+	clear(n.items[i:])
+	n.items = n.items[:i]
+	if len(n.children) != 0 {
+		next.children = make([]*btreeNode, 0, btreeMaxItems+1)
+		next.children = append(next.children, n.children[i+1:]...)
+		clear(n.children[i+1:])
+		n.children = n.children[:i+1]
+	}
+	return item, next
+}
+
+// insert adds the entry to the subtree of the mutable non-full node n.
+func (t *btree) insert(n *btreeNode, e kvEntry) (kvEntry, bool) {
+	i, found := n.find(e.key)
+	if found {
+		old := n.items[i]
+		n.items[i] = e
+		return old, true
+	}
+	if len(n.children) == 0 {
+		n.items = insertAt(n.items, i, e)
+		return kvEntry{}, false
+	}
+	if len(n.children[i].items) >= btreeMaxItems {
+		first := t.mutableChild(n, i)
+		item, second := t.split(first, btreeMaxItems/2)
+		n.items = insertAt(n.items, i, item)
+		n.children = insertAt(n.children, i+1, second)
+		switch {
+		case e.key == item.key:
+			n.items[i] = e
+			return item, true
+		case e.key > item.key:
+			i++
+		}
+	}
+	return t.insert(t.mutableChild(n, i), e)
+}
+
+// delete removes the entry with the given key from the tree returning it.
+func (t *btree) delete(key string) (kvEntry, bool) {
+	if t.root == nil {
+		return kvEntry{}, false
+	}
+	t.write()
+	t.root = t.mutable(t.root)
+	e, ok := t.remove(t.root, key, false)
+	if len(t.root.items) == 0 {
+		if len(t.root.children) != 0 {
+			t.root = t.root.children[0]
+		} else {
+			t.root = nil
+		}
+	}
+	if ok {
+		t.len--
+	}
+	return e, ok
+}
+
+// remove removes the entry with the given key (or the last one if last is
+// set) from the subtree of the mutable node n, every node it descends into
+// has more than the minimum number of items, so it can be removed without
+// rebalancing the tree upwards.
+func (t *btree) remove(n *btreeNode, key string, last bool) (kvEntry, bool) {
+	var (
+		i     int
+		found bool
+	)
+	if last {
+		i = len(n.items)
+		if len(n.children) == 0 {
+			e := n.items[i-1]
+			n.items = removeAt(n.items, i-1)
+			return e, true
+		}
+	} else {
+		i, found = n.find(key)
+		if len(n.children) == 0 {
+			if !found {
+				return kvEntry{}, false
+			}
+			e := n.items[i]
+			n.items = removeAt(n.items, i)
+			return e, true
+		}
+	}
+	if len(n.children[i].items) <= btreeMinItems {
+		t.grow(n, i)
+		return t.remove(n, key, last)
+	}
+	child := t.mutableChild(n, i)
+	if found {
+		e := n.items[i]
+		n.items[i], _ = t.remove(child, "", true)
+		return e, true
+	}
+	return t.remove(child, key, last)
+}
+
+// grow makes the i-th child of the mutable node n have more than the minimum
+// number of items stealing an item from its sibling or merging it with one.
+func (t *btree) grow(n *btreeNode, i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > btreeMinItems:
+		child, left := t.mutableChild(n, i), t.mutableChild(n, i-1)
+		child.items = insertAt(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = removeAt(left.items, len(left.items)-1)
+		if len(left.children) != 0 {
+			child.children = insertAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = removeAt(left.children, len(left.children)-1)
+		}
+	case i < len(n.items) && len(n.children[i+1].items) > btreeMinItems:
+		child, right := t.mutableChild(n, i), t.mutableChild(n, i+1)
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeAt(right.items, 0)
+		if len(right.children) != 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child, next := t.mutableChild(n, i), n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, next.items...)
+		child.children = append(child.children, next.children...)
+		n.items = removeAt(n.items, i)
+		n.children = removeAt(n.children, i+1)
+	}
+}
+
+// insertAt inserts v into s at the i-th position.
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+// removeAt removes the i-th element from s.
+func removeAt[T any](s []T, i int) []T {
+	var zero T
+	copy(s[i:], s[i+1:])
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
+
+// btreeFrame is a position in the node for iteration: the index of the next
+// item for ascending iteration or the number of remaining items for
+// descending one.
+type btreeFrame struct {
+	n *btreeNode
+	i int
+}
+
+// btreeIter iterates over B-tree snapshot entries from the range.
+type btreeIter struct {
+	r     KeyRange
+	stack []btreeFrame
+}
+
+// newBTreeIter creates an iterator over the tree with the given root (that
+// shouldn't be changed) positioned before the first entry of the range.
+func newBTreeIter(root *btreeNode, r KeyRange) *btreeIter {
+	it := &btreeIter{r: r}
+	if r.Reverse {
+		end, bounded := r.upperBound()
+		for n := root; n != nil; {
+			i := len(n.items)
+			if bounded {
+				i, _ = n.find(end)
+			}
+			it.push(n, i)
+			n = n.child(i)
+		}
+	} else {
+		start := r.lowerBound()
+		for n := root; n != nil; {
+			i, _ := n.find(start)
+			it.push(n, i)
+			n = n.child(i)
+		}
+	}
+	return it
+}
+
+// push adds the frame to the stack.
+func (it *btreeIter) push(n *btreeNode, i int) {
+	it.stack = append(it.stack, btreeFrame{n: n, i: i})
+}
+
+// descend pushes frames for the path to the first (or the last for reverse
+// iteration) entry of the subtree.
+func (it *btreeIter) descend(n *btreeNode) {
+	for ; n != nil; n = n.child(it.stack[len(it.stack)-1].i) {
+		if it.r.Reverse {
+			it.push(n, len(n.items))
+		} else {
+			it.push(n, 0)
+		}
+	}
+}
+
+// ascend calls f for all entries of the tree in key order, the tree must not
+// be changed by f.
+func (t *btree) ascend(f func(e kvEntry)) {
+	t.root.ascend(f)
+}
+
+func (n *btreeNode) ascend(f func(e kvEntry)) {
+	if n == nil {
+		return
+	}
+	for i, e := range n.items {
+		n.child(i).ascend(f)
+		f(e)
+	}
+	n.child(len(n.items)).ascend(f)
+}
+
+// child returns the i-th child of the node or nil for leaves.
+func (n *btreeNode) child(i int) *btreeNode {
+	if len(n.children) == 0 {
+		return nil
+	}
+	return n.children[i]
+}
+
+// next returns the next entry from the range, false is returned when there
+// are no more entries.
+func (it *btreeIter) next() (kvEntry, bool) {
+	for len(it.stack) != 0 {
+		f := &it.stack[len(it.stack)-1]
+		var e kvEntry
+		switch {
+		case !it.r.Reverse && f.i < len(f.n.items):
+			e = f.n.items[f.i]
+			f.i++
+			it.descend(f.n.child(f.i))
+		case it.r.Reverse && f.i > 0:
+			f.i--
+			e = f.n.items[f.i]
+			it.descend(f.n.child(f.i))
+		default:
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+		if !it.r.contains(e.key) {
+			// Iteration started at the range bound, so the rest of
+			// entries are out of range too.
+			it.stack = nil
+			return kvEntry{}, false
+		}
+		return e, true
+	}
+	return kvEntry{}, false
+}
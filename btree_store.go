@@ -0,0 +1,188 @@
+package xorkv
+
+import (
+	"sync"
+)
+
+// BTreeStore is an in-memory implementation of a Store keeping key-value
+// pairs ordered in a B-tree, so unlike MemoryStore it seeks ranges in
+// O(log n) without sorting keys and its iterators use cheap copy-on-write
+// snapshots. Checksums are calculated incrementally, but they're the same as
+// MemoryStore ones for the same data and options.
+type BTreeStore struct {
+	mut  sync.RWMutex
+	tree btree
+	sums map[KeyPrefix]Accumulator
+
+	opts options
+}
+
+// NewBTreeStore creates a new BTreeStore object.
+func NewBTreeStore(opts ...Option) *BTreeStore {
+	return &BTreeStore{
+		sums: make(map[KeyPrefix]Accumulator),
+		opts: newOptions(opts),
+	}
+}
+
+// Get implements the Store interface.
+func (s *BTreeStore) Get(key []byte) ([]byte, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	if e, ok := s.tree.get(string(key)); ok {
+		return e.val, nil
+	}
+	return nil, ErrKeyNotFound
+}
+
+// prefixSum returns the accumulator for the key's prefix.
+func (s *BTreeStore) prefixSum(k string) Accumulator {
+	p := keyPrefix(k)
+	acc, ok := s.sums[p]
+	if !ok {
+		acc = s.opts.acc.Zero()
+		s.sums[p] = acc
+	}
+	return acc
+}
+
+// put puts a key-value pair into the store updating the checksum, it's
+// supposed to be called with mutex locked.
+func (s *BTreeStore) put(key string, value []byte) {
+	old, replaced := s.tree.set(kvEntry{key: key, val: value})
+	if s.opts.inScope([]byte(key)) {
+		if replaced {
+			s.prefixSum(key).Remove(s.opts.hashKV(key, old.val))
+		}
+		s.prefixSum(key).Add(s.opts.hashKV(key, value))
+	}
+}
+
+// Put implements the Store interface. Never returns an error.
+func (s *BTreeStore) Put(key, value []byte) error {
+	vcopy := make([]byte, len(value))
+	copy(vcopy, value)
+	s.mut.Lock()
+	s.put(string(key), vcopy)
+	s.mut.Unlock()
+	return nil
+}
+
+// drop deletes a key-value pair from the store updating the checksum, it's
+// supposed to be called with mutex locked.
+func (s *BTreeStore) drop(key string) {
+	old, ok := s.tree.delete(key)
+	if ok && s.opts.inScope([]byte(key)) {
+		s.prefixSum(key).Remove(s.opts.hashKV(key, old.val))
+	}
+}
+
+// Delete implements the Store interface. Never returns an error.
+func (s *BTreeStore) Delete(key []byte) error {
+	s.mut.Lock()
+	s.drop(string(key))
+	s.mut.Unlock()
+	return nil
+}
+
+// Batch implements the Store interface and returns a compatible Batch.
+func (s *BTreeStore) Batch() Batch {
+	return newMemoryBatch()
+}
+
+// PutBatch implements the Store interface. Never returns an error.
+func (s *BTreeStore) PutBatch(batch Batch) error {
+	b := batch.(*MemoryBatch)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for k := range b.del {
+		s.drop(k)
+	}
+	for k, v := range b.mem {
+		s.put(k, v)
+	}
+	return nil
+}
+
+// Seek implements the Store interface.
+func (s *BTreeStore) Seek(key []byte, f func(k, v []byte)) {
+	s.SeekRange(KeyRange{Prefix: key}, f)
+}
+
+// SeekRange implements the Store interface.
+func (s *BTreeStore) SeekRange(r KeyRange, f func(k, v []byte)) {
+	iterate(s.NewIterator(r), f)
+}
+
+// NewIterator implements the Store interface, the iterator works with a
+// snapshot of the tree.
+func (s *BTreeStore) NewIterator(r KeyRange) Iterator {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return &btreeIterator{it: newBTreeIter(s.tree.snapshot(), r)}
+}
+
+// Checksum implements the Store interface.
+func (s *BTreeStore) Checksum() Checksum {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.opts.checksum(newPrefixChecksums(s.opts, s.sums).total())
+}
+
+// ChecksumByPrefix implements the Store interface.
+func (s *BTreeStore) ChecksumByPrefix() PrefixChecksums {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return newPrefixChecksums(s.opts, s.sums)
+}
+
+// Close implements Store interface and clears up memory. Never returns an
+// error.
+func (s *BTreeStore) Close() error {
+	s.mut.Lock()
+	s.tree.reset()
+	s.sums = make(map[KeyPrefix]Accumulator)
+	s.mut.Unlock()
+	return nil
+}
+
+// btreeIterator is BTreeStore Iterator.
+type btreeIterator struct {
+	it  *btreeIter
+	key []byte
+	val []byte
+}
+
+// Next implements the Iterator interface.
+func (it *btreeIterator) Next() bool {
+	it.key, it.val = nil, nil
+	if it.it == nil {
+		return false
+	}
+	e, ok := it.it.next()
+	if ok {
+		it.key, it.val = []byte(e.key), e.val
+	}
+	return ok
+}
+
+// Key implements the Iterator interface.
+func (it *btreeIterator) Key() []byte {
+	return it.key
+}
+
+// Value implements the Iterator interface.
+func (it *btreeIterator) Value() []byte {
+	return it.val
+}
+
+// Err implements the Iterator interface, it's always nil.
+func (it *btreeIterator) Err() error {
+	return nil
+}
+
+// Release implements the Iterator interface.
+func (it *btreeIterator) Release() {
+	it.it = nil
+	it.key, it.val = nil, nil
+}
@@ -0,0 +1,70 @@
+package xorkv
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newBTreeStoreForTesting(t *testing.T) Store {
+	return NewBTreeStore()
+}
+
+func TestBTreeStoreChecksum(t *testing.T) {
+	keys := [][]byte{stKey("a"), stKey("b"), stKey("c"), AppendPrefix(STAccount, []byte("a")),
+		AppendPrefixInt(DataBlock, 1), SYSCurrentBlock.Bytes()}
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator),
+		new(MuHashAccumulator), new(ECMHAccumulator)} {
+		t.Run(acc.Kind().String(), func(t *testing.T) {
+			var (
+				r    = rand.New(rand.NewSource(42))
+				opts = []Option{WithAccumulator(acc), WithScope(PrefixScope(STStorage, DataBlock))}
+				s    = NewBTreeStore(opts...)
+				ref  = NewMemoryStore(opts...)
+			)
+			for i := 0; i < 200; i++ {
+				k, v := keys[r.Intn(len(keys))], []byte{byte(r.Intn(3))}
+				switch op := r.Intn(5); {
+				case op < 2:
+					require.NoError(t, s.Put(k, v))
+					require.NoError(t, ref.Put(k, v))
+				case op < 4:
+					require.NoError(t, s.Delete(k))
+					require.NoError(t, ref.Delete(k))
+				default:
+					b := s.Batch()
+					b.Put(k, v)
+					b.Delete(keys[r.Intn(len(keys))])
+					require.NoError(t, s.PutBatch(b))
+					require.NoError(t, ref.PutBatch(b))
+				}
+				require.Equal(t, ref.Checksum(), s.Checksum(), "op %d", i)
+				require.Equal(t, ref.ChecksumByPrefix().Map(), s.ChecksumByPrefix().Map(), "op %d", i)
+				require.Equal(t, storeContents(ref), storeContents(s), "op %d", i)
+			}
+		})
+	}
+}
+
+func TestBTreeStoreCached(t *testing.T) {
+	ps := NewBTreeStore(WithChecksumRecord())
+	s := NewMemCachedStore(ps, WithChecksumRecord())
+	for i := 0; i < 500; i++ {
+		require.NoError(t, s.Put(AppendPrefixInt(STStorage, i), []byte{1}))
+	}
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(AppendPrefixInt(STStorage, 1)))
+	require.NoError(t, s.Put(AppendPrefixInt(STStorage, 1000), []byte{2}))
+	require.Equal(t, 500, len(iteratorPairs(t, s.NewIterator(KeyRange{Prefix: STStorage.Bytes()}))))
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, s.Checksum(), ps.Checksum())
+
+	s, err = OpenMemCachedStore(ps, WithChecksumRecord(), WithVerifyRecord())
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+	require.NoError(t, s.Close())
+	require.Empty(t, storeContents(ps))
+}
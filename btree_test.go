@@ -0,0 +1,156 @@
+package xorkv
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// checkBTree checks B-tree invariants and the number of entries.
+func checkBTree(t *testing.T, tr *btree) {
+	var (
+		count int
+		depth = -1
+		prev  *string
+		walk  func(n *btreeNode, level int, root bool)
+	)
+	walk = func(n *btreeNode, level int, root bool) {
+		require.LessOrEqual(t, len(n.items), btreeMaxItems)
+		if !root {
+			require.GreaterOrEqual(t, len(n.items), btreeMinItems)
+		}
+		if len(n.children) == 0 {
+			if depth == -1 {
+				depth = level
+			}
+			require.Equal(t, depth, level, "leaves should have the same depth")
+		} else {
+			require.Equal(t, len(n.items)+1, len(n.children))
+		}
+		for i := range n.items {
+			if len(n.children) != 0 {
+				walk(n.children[i], level+1, false)
+			}
+			if prev != nil {
+				require.Less(t, *prev, n.items[i].key)
+			}
+			prev = &n.items[i].key
+			count++
+		}
+		if len(n.children) != 0 {
+			walk(n.children[len(n.items)], level+1, false)
+		}
+	}
+	if tr.root != nil {
+		require.NotEmpty(t, tr.root.items)
+		walk(tr.root, 0, true)
+	}
+	require.Equal(t, tr.len, count)
+}
+
+// btreeKeys returns keys from the range yielded by the B-tree iterator.
+func btreeKeys(root *btreeNode, r KeyRange) []string {
+	var (
+		res []string
+		it  = newBTreeIter(root, r)
+	)
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		res = append(res, e.key)
+	}
+	return res
+}
+
+func TestBTreeModel(t *testing.T) {
+	var (
+		r     = rand.New(rand.NewSource(42))
+		tr    btree
+		model = make(map[string]string)
+	)
+	for i := 0; i < 20000; i++ {
+		k := fmt.Sprintf("%04d", r.Intn(2000))
+		if r.Intn(3) == 0 {
+			e, ok := tr.delete(k)
+			v, present := model[k]
+			require.Equal(t, present, ok)
+			require.Equal(t, v, string(e.val))
+			delete(model, k)
+		} else {
+			v := fmt.Sprint(i)
+			e, ok := tr.set(kvEntry{key: k, val: []byte(v)})
+			old, present := model[k]
+			require.Equal(t, present, ok)
+			require.Equal(t, old, string(e.val))
+			model[k] = v
+		}
+		if i%1000 == 0 {
+			checkBTree(t, &tr)
+		}
+	}
+	checkBTree(t, &tr)
+	for k, v := range model {
+		e, ok := tr.get(k)
+		require.True(t, ok)
+		require.Equal(t, v, string(e.val))
+	}
+	_, ok := tr.get("x")
+	require.False(t, ok)
+
+	keys := make([]string, 0, len(model))
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i := 0; i < 200; i++ {
+		rng := KeyRange{Prefix: []byte(fmt.Sprint(r.Intn(20))), Reverse: r.Intn(2) == 0}
+		if r.Intn(2) == 0 {
+			rng.Start = []byte(fmt.Sprintf("%04d", r.Intn(2000)))
+		}
+		if r.Intn(2) == 0 {
+			rng.End = []byte(fmt.Sprintf("%04d", r.Intn(2000)))
+		}
+		var expected []string
+		for _, k := range keys {
+			if rng.contains(k) {
+				expected = append(expected, k)
+			}
+		}
+		rng.sort(expected)
+		require.Equal(t, expected, btreeKeys(tr.root, rng), "range %v", rng)
+	}
+
+	// Remove everything.
+	for _, k := range keys {
+		_, ok := tr.delete(k)
+		require.True(t, ok)
+	}
+	checkBTree(t, &tr)
+	require.Nil(t, tr.root)
+	require.Empty(t, btreeKeys(tr.root, KeyRange{}))
+}
+
+func TestBTreeSnapshot(t *testing.T) {
+	var (
+		tr    btree
+		snaps []*btreeNode
+		all   [][]string
+	)
+	for i := 0; i < 5000; i++ {
+		k := fmt.Sprintf("%05d", (i*7919)%5000)
+		tr.set(kvEntry{key: k})
+		if i%5 == 0 {
+			tr.delete(fmt.Sprintf("%05d", (i*31)%5000))
+		}
+		if i%500 == 0 {
+			snaps = append(snaps, tr.snapshot())
+			all = append(all, btreeKeys(tr.root, KeyRange{}))
+		}
+	}
+	checkBTree(t, &tr)
+	// Snapshots are not affected by later changes.
+	for i, root := range snaps {
+		require.Equal(t, all[i], btreeKeys(root, KeyRange{}))
+	}
+}
@@ -0,0 +1,135 @@
+package xorkv
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Change is a single pending store change.
+type Change struct {
+	Key []byte
+	// Value is the new value, nil for deletions.
+	Value []byte
+	// Prev is the value key had before the change, nil if it didn't exist.
+	Prev []byte
+}
+
+// IsDelete returns true if the change is a deletion.
+func (c Change) IsDelete() bool {
+	return c.Value == nil
+}
+
+// Changeset is a set of store changes ordered by key.
+type Changeset struct {
+	Changes []Change
+}
+
+// Changeset returns all pending (not yet persisted) changes in the key order.
+// Previous values of keys out of checksum scope are read from the lower store
+// when they're needed for the first time, so it fails if they can't be read.
+func (s *MemCachedStore) Changeset() (Changeset, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.changeset()
+}
+
+// changeset is an internal implementation of Changeset, it's supposed to be
+// called with mutex locked.
+func (s *MemCachedStore) changeset() (Changeset, error) {
+	res := Changeset{Changes: make([]Change, 0, s.cache.len)}
+	it := newBTreeIter(s.cache.root, KeyRange{})
+	for e, ok := it.next(); ok; e, ok = it.next() {
+		prev, err := s.origValueOf(e.key)
+		if err != nil {
+			return res, err
+		}
+		ch := Change{Key: []byte(e.key), Prev: prev}
+		if !e.deleted {
+			ch.Value = e.val
+		}
+		res.Changes = append(res.Changes, ch)
+	}
+	return res, nil
+}
+
+// delta returns the checksum difference the changeset introduces calculated
+// with the given options.
+func (c Changeset) delta(opts options) Accumulator {
+	acc := opts.acc.Zero()
+	for _, ch := range c.Changes {
+		ch.addDelta(acc, opts, false)
+	}
+	return acc
+}
+
+// addDelta adds the checksum difference the change introduces to acc (or
+// the opposite one if revert is set).
+func (c Change) addDelta(acc Accumulator, opts options, revert bool) {
+	if !opts.inScope(c.Key) {
+		return
+	}
+	add, remove := acc.Add, acc.Remove
+	if revert {
+		add, remove = remove, add
+	}
+	if c.Prev != nil {
+		remove(opts.hashKV(string(c.Key), c.Prev))
+	}
+	if !c.IsDelete() {
+		add(opts.hashKV(string(c.Key), c.Value))
+	}
+}
+
+// addChangeSum adds the change to acc the same way
+// MemCachedStore.ChangeChecksum does.
+func (c Change) addChangeSum(acc Accumulator, opts options) {
+	switch {
+	case !opts.inScope(c.Key):
+	case !c.IsDelete():
+		acc.Add(opts.hashKV(string(c.Key), c.Value))
+	case c.Prev != nil:
+		// Deletion of absent key is a no-op.
+		acc.Add(opts.hash.Sum(c.Key))
+	}
+}
+
+// Apply replays the changeset onto the given store in one batch. The store is
+// expected to have the same values as the changeset was created against, so
+// previous values of all changed keys (including the ones out of checksum
+// scope) are checked before anything is written and ErrChecksumMismatch is
+// returned if they differ. The resulting store checksum is then checked to be
+// equal to the initial one combined with the changeset delta, this can only
+// fail if the store is changed concurrently and the store is left modified
+// then.
+func (c Changeset) Apply(s Store) error {
+	for _, ch := range c.Changes {
+		cur, err := s.Get(ch.Key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if (err == nil) != (ch.Prev != nil) || !bytes.Equal(cur, ch.Prev) {
+			return fmt.Errorf("%w: key %x has different value than the changeset expects",
+				ErrChecksumMismatch, ch.Key)
+		}
+	}
+	base := s.ChecksumByPrefix()
+	expected := base.total()
+	expected.Combine(c.delta(base.opts))
+
+	batch := s.Batch()
+	for _, ch := range c.Changes {
+		if ch.IsDelete() {
+			batch.Delete(ch.Key)
+		} else {
+			batch.Put(ch.Key, ch.Value)
+		}
+	}
+	if err := s.PutBatch(batch); err != nil {
+		return err
+	}
+	if exp, res := base.opts.checksum(expected), s.Checksum(); !res.Equals(exp) {
+		return fmt.Errorf("%w: expected %s after applying changeset, got %s",
+			ErrChecksumMismatch, exp, res)
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package xorkv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Changeset stream format constants.
+const (
+	changesetMagic   = "XKVC"
+	changesetVersion = 1
+	// maxChangeFieldLen limits key, value and base state sizes.
+	maxChangeFieldLen = 1 << 26
+)
+
+// Changeset stream record flags, every record starts with them.
+const (
+	// recordValue marks changes having a new value (not deletions).
+	recordValue byte = 1 << iota
+	// recordPrev marks changes having a previous value.
+	recordPrev
+	// recordEnd marks the final record containing the number of changes.
+	recordEnd byte = 0x80
+)
+
+// ErrInvalidChangeset is returned when changeset stream is truncated,
+// corrupted or can't be encoded.
+var ErrInvalidChangeset = errors.New("invalid changeset")
+
+// ChangesetHeader describes the changeset stream, it's written before any
+// changes.
+type ChangesetHeader struct {
+	// Base is the checksum of the store the changeset is made against.
+	Base Checksum
+	// Result is the checksum of the store with the changeset applied.
+	Result Checksum
+	// Change is the checksum of the changeset itself (see
+	// MemCachedStore.ChangeChecksum).
+	Change Checksum
+	// BaseState is the serialized accumulator state Base is calculated from,
+	// it allows to verify Result.
+	BaseState []byte
+}
+
+// Encoder writes changeset stream. The format is magic, version, header
+// checksums (in binary form), varint-prefixed base accumulator state and
+// change records followed by the end record. Every change record is flags,
+// varint-prefixed key, value and previous value (if present according to
+// flags). The end record is recordEnd and varint number of changes. The
+// header and every record are followed by CRC-32C of all the data written
+// so far, so any truncation or corruption is detected as soon as the
+// damaged record is read.
+type Encoder struct {
+	w     *bufio.Writer
+	crc   uint32
+	buf   []byte
+	last  []byte
+	count uint64
+	err   error
+}
+
+// NewEncoder creates an Encoder writing to w and writes the header. Changes
+// are expected to be encoded in the key order, they're not checked against
+// header checksums.
+func NewEncoder(w io.Writer, h ChangesetHeader) (*Encoder, error) {
+	if len(h.BaseState) > maxChangeFieldLen {
+		return nil, fmt.Errorf("%w: base state is too long", ErrInvalidChangeset)
+	}
+	e := &Encoder{w: bufio.NewWriter(w)}
+	buf := append([]byte(changesetMagic), changesetVersion)
+	for _, c := range []Checksum{h.Base, h.Result, h.Change} {
+		data, err := c.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidChangeset, err)
+		}
+		buf = append(buf, data...)
+	}
+	buf = appendUvarint(buf, uint64(len(h.BaseState)))
+	buf = append(buf, h.BaseState...)
+	if err := e.write(buf); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// write writes the record followed by the stream CRC.
+func (e *Encoder) write(record []byte) error {
+	e.crc = crc32.Update(e.crc, crcTable, record)
+	_, err := e.w.Write(binary.LittleEndian.AppendUint32(record, e.crc))
+	e.buf = record[:0]
+	return err
+}
+
+// Encode writes a single change, changes must be strictly ordered by key and
+// keys and values can't be longer than Decoder accepts (64 MiB).
+func (e *Encoder) Encode(c Change) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.count != 0 && bytes.Compare(e.last, c.Key) >= 0 {
+		return fmt.Errorf("%w: key %x is not in order", ErrInvalidChangeset, c.Key)
+	}
+	if len(c.Key) > maxChangeFieldLen || len(c.Value) > maxChangeFieldLen ||
+		len(c.Prev) > maxChangeFieldLen {
+		return fmt.Errorf("%w: field is too long", ErrInvalidChangeset)
+	}
+	var flags byte
+	if !c.IsDelete() {
+		flags |= recordValue
+	}
+	if c.Prev != nil {
+		flags |= recordPrev
+	}
+	buf := append(e.buf, flags)
+	buf = appendUvarint(buf, uint64(len(c.Key)))
+	buf = append(buf, c.Key...)
+	for _, v := range [][]byte{c.Value, c.Prev} {
+		if v != nil {
+			buf = appendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+	}
+	e.last = append(e.last[:0], c.Key...)
+	e.count++
+	e.err = e.write(buf)
+	return e.err
+}
+
+// Close writes the end record and flushes the data, it doesn't close the
+// underlying writer. Encoder can't be used after Close.
+func (e *Encoder) Close() error {
+	if e.err != nil {
+		return e.err
+	}
+	err := e.write(appendUvarint(append(e.buf, recordEnd), e.count))
+	if err == nil {
+		err = e.w.Flush()
+	}
+	if err != nil {
+		e.err = err
+		return err
+	}
+	e.err = fmt.Errorf("%w: encoder is closed", ErrInvalidChangeset)
+	return nil
+}
+
+// EncodeChangeset writes all pending changes to w (see Changeset) with the
+// header describing them.
+func (s *MemCachedStore) EncodeChangeset(w io.Writer) error {
+	s.mut.Lock()
+	cs, err := s.changeset()
+	if err != nil {
+		s.mut.Unlock()
+		return err
+	}
+	h := s.changesetHeader(cs)
+	s.mut.Unlock()
+	return encodeChangeset(w, h, cs)
+}
+
+// encodeChangeset writes the whole changeset with the given header to w.
+func encodeChangeset(w io.Writer, h ChangesetHeader, cs Changeset) error {
+	e, err := NewEncoder(w, h)
+	if err != nil {
+		return err
+	}
+	for _, ch := range cs.Changes {
+		if err := e.Encode(ch); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
+
+// changesetHeader returns the header for the given changeset of the store,
+// it's supposed to be called with mutex locked.
+func (s *MemCachedStore) changesetHeader(cs Changeset) ChangesetHeader {
+	result := s.stateSum()
+	base := cloneAccumulator(result)
+	change := s.opts.acc.Zero()
+	for _, ch := range cs.Changes {
+		ch.addDelta(base, s.opts, true)
+		ch.addChangeSum(change, s.opts)
+	}
+	// Accumulators can always be serialized.
+	state, _ := base.MarshalBinary()
+	return ChangesetHeader{
+		Base:      s.opts.checksum(base),
+		Result:    s.opts.checksum(result),
+		Change:    s.opts.checksum(change),
+		BaseState: state,
+	}
+}
+
+// Decoder reads changeset stream written by Encoder verifying it.
+type Decoder struct {
+	*crcReader
+	opts options
+	hdr  ChangesetHeader
+
+	// result and change are calculated from the decoded changes.
+	result Accumulator
+	change Accumulator
+	last   []byte
+	count  uint64
+	done   bool
+}
+
+// NewDecoder creates a Decoder reading from r and reads the header. Options
+// should match the ones used by the store the changeset was made for, the
+// header must have checksums calculated with the same algorithm and the
+// scope is used to calculate them from the changes.
+func NewDecoder(r io.Reader, opts ...Option) (*Decoder, error) {
+	return newDecoder(r, newOptions(opts))
+}
+
+// newDecoder creates a Decoder with the given options.
+func newDecoder(r io.Reader, opts options) (*Decoder, error) {
+	d := &Decoder{
+		crcReader: newCRCReader(r, ErrInvalidChangeset),
+		opts:      opts,
+	}
+	magic := make([]byte, len(changesetMagic)+1)
+	if err := d.read(magic); err != nil {
+		return nil, err
+	}
+	if string(magic[:len(changesetMagic)]) != changesetMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrInvalidChangeset)
+	}
+	if magic[len(changesetMagic)] != changesetVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidChangeset,
+			magic[len(changesetMagic)])
+	}
+	var (
+		expected = d.opts.checksum(d.opts.acc.Zero())
+		sums     = []*Checksum{&d.hdr.Base, &d.hdr.Result, &d.hdr.Change}
+	)
+	for _, c := range sums {
+		head := make([]byte, 5)
+		if err := d.read(head); err != nil {
+			return nil, err
+		}
+		data := append(head, make([]byte, head[4])...)
+		if err := d.read(data[5:]); err != nil {
+			return nil, err
+		}
+		if err := c.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidChangeset, err)
+		}
+	}
+	state, err := d.readBytes(maxChangeFieldLen)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkCRC(); err != nil {
+		return nil, err
+	}
+	for _, c := range sums {
+		if c.Algorithm() != expected.Algorithm() {
+			return nil, fmt.Errorf("%w: changeset has %s, expected %s", ErrChecksumMismatch,
+				c.Algorithm(), expected.Algorithm())
+		}
+	}
+	d.hdr.BaseState = state
+	d.result = d.opts.acc.Zero()
+	if err := d.result.UnmarshalBinary(state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidChangeset, err)
+	}
+	if base := d.opts.checksum(cloneAccumulator(d.result)); !base.Equals(d.hdr.Base) {
+		return nil, fmt.Errorf("%w: base state has %s, expected %s", ErrChecksumMismatch,
+			base, d.hdr.Base)
+	}
+	d.change = d.opts.acc.Zero()
+	return d, nil
+}
+
+// Header returns the changeset header.
+func (d *Decoder) Header() ChangesetHeader {
+	return d.hdr
+}
+
+// Decode returns the next change. It returns io.EOF after the last change
+// when the end of the stream is reached and the resulting checksums match
+// the ones from the header. Changes are verified as they're read, but
+// checksum mismatch can only be detected at the end of the stream, so the
+// changes decoded shouldn't be treated as valid before that.
+func (d *Decoder) Decode() (Change, error) {
+	var c Change
+	if d.done {
+		return c, io.EOF
+	}
+	flags, err := d.readByte()
+	if err != nil {
+		return c, err
+	}
+	if flags == recordEnd {
+		return c, d.finish()
+	}
+	if flags&^(recordValue|recordPrev) != 0 {
+		return c, fmt.Errorf("%w: bad record flags %x", ErrInvalidChangeset, flags)
+	}
+	if c.Key, err = d.readBytes(maxChangeFieldLen); err != nil {
+		return c, err
+	}
+	if flags&recordValue != 0 {
+		if c.Value, err = d.readBytes(maxChangeFieldLen); err != nil {
+			return c, err
+		}
+	}
+	if flags&recordPrev != 0 {
+		if c.Prev, err = d.readBytes(maxChangeFieldLen); err != nil {
+			return c, err
+		}
+	}
+	if err := d.checkCRC(); err != nil {
+		return c, err
+	}
+	if d.count != 0 && bytes.Compare(d.last, c.Key) >= 0 {
+		return c, fmt.Errorf("%w: key %x is not in order", ErrInvalidChangeset, c.Key)
+	}
+	d.last = append(d.last[:0], c.Key...)
+	d.count++
+	c.addDelta(d.result, d.opts, false)
+	c.addChangeSum(d.change, d.opts)
+	return c, nil
+}
+
+// finish reads the end record and compares the calculated checksums with the
+// header ones.
+func (d *Decoder) finish() error {
+	n, err := d.readUvarint()
+	if err != nil {
+		return err
+	}
+	if err := d.checkCRC(); err != nil {
+		return err
+	}
+	if n != d.count {
+		return fmt.Errorf("%w: %d changes expected, %d decoded", ErrInvalidChangeset, n, d.count)
+	}
+	if res := d.opts.checksum(d.result); !res.Equals(d.hdr.Result) {
+		return fmt.Errorf("%w: changeset results in %s, expected %s", ErrChecksumMismatch,
+			res, d.hdr.Result)
+	}
+	if ch := d.opts.checksum(d.change); !ch.Equals(d.hdr.Change) {
+		return fmt.Errorf("%w: change checksum is %s, expected %s", ErrChecksumMismatch,
+			ch, d.hdr.Change)
+	}
+	d.done = true
+	return io.EOF
+}
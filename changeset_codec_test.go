@@ -0,0 +1,232 @@
+package xorkv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// decodeChangeset reads the whole changeset stream.
+func decodeChangeset(r io.Reader, opts ...Option) (Changeset, error) {
+	var cs Changeset
+	d, err := NewDecoder(r, opts...)
+	if err != nil {
+		return cs, err
+	}
+	for {
+		ch, err := d.Decode()
+		if err == io.EOF {
+			return cs, nil
+		}
+		if err != nil {
+			return cs, err
+		}
+		cs.Changes = append(cs.Changes, ch)
+	}
+}
+
+// newChangesetForTesting returns a store with some pending changes.
+func newChangesetForTesting(t *testing.T, opts ...Option) *MemCachedStore {
+	ps := NewMemoryStore(opts...)
+	require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+	require.NoError(t, ps.Put(stKey("lower2"), []byte("v2")))
+	require.NoError(t, ps.Put(stKey("lower3"), []byte{}))
+	require.NoError(t, ps.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	s := NewMemCachedStore(ps, opts...)
+	require.NoError(t, s.Put(stKey("lower1"), []byte("new1")))
+	require.NoError(t, s.Delete(stKey("lower2")))
+	require.NoError(t, s.Delete(stKey("lower3")))
+	require.NoError(t, s.Put(stKey("new"), []byte{}))
+	require.NoError(t, s.Delete(stKey("absent")))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{2}))
+	return s
+}
+
+func TestChangesetCodec(t *testing.T) {
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator),
+		new(MuHashAccumulator), new(ECMHAccumulator)} {
+		t.Run(acc.Kind().String(), func(t *testing.T) {
+			opts := []Option{WithAccumulator(acc)}
+			s := newChangesetForTesting(t, opts...)
+			buf := new(bytes.Buffer)
+			require.NoError(t, s.EncodeChangeset(buf))
+
+			d, err := NewDecoder(bytes.NewReader(buf.Bytes()), opts...)
+			require.NoError(t, err)
+			h := d.Header()
+			require.Equal(t, s.ps.Checksum(), h.Base)
+			require.Equal(t, s.Checksum(), h.Result)
+			require.Equal(t, s.ChangeChecksum(), h.Change)
+
+			cs, err := decodeChangeset(bytes.NewReader(buf.Bytes()), opts...)
+			require.NoError(t, err)
+			require.Equal(t, getChangeset(t, s), cs)
+
+			replica := NewMemoryStore(opts...)
+			copyStore(t, replica, s.ps)
+			require.NoError(t, cs.Apply(replica))
+			require.Equal(t, s.Checksum(), replica.Checksum())
+
+			// Wrong options.
+			_, err = NewDecoder(bytes.NewReader(buf.Bytes()), WithHashFunc(SHA3))
+			require.True(t, errors.Is(err, ErrChecksumMismatch))
+		})
+	}
+}
+
+func TestChangesetCodecEmpty(t *testing.T) {
+	s := NewMemCachedStore(NewMemoryStore())
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.EncodeChangeset(buf))
+	cs, err := decodeChangeset(buf)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(cs.Changes))
+}
+
+func TestChangesetCodecDamaged(t *testing.T) {
+	s := newChangesetForTesting(t)
+	buf := new(bytes.Buffer)
+	require.NoError(t, s.EncodeChangeset(buf))
+	data := buf.Bytes()
+
+	for i := 0; i < len(data); i++ {
+		_, err := decodeChangeset(bytes.NewReader(data[:i]))
+		require.True(t, errors.Is(err, ErrInvalidChangeset), "truncated at %d: %v", i, err)
+	}
+	damaged := make([]byte, len(data))
+	for i := range data {
+		copy(damaged, data)
+		damaged[i] ^= 0x10
+		_, err := decodeChangeset(bytes.NewReader(damaged))
+		require.Error(t, err, "damaged at %d", i)
+	}
+	// Trailing data is not read.
+	_, err := decodeChangeset(bytes.NewReader(append(data, 0)))
+	require.NoError(t, err)
+}
+
+func TestChangesetCodecMismatch(t *testing.T) {
+	s := newChangesetForTesting(t)
+	cs := getChangeset(t, s)
+	s.mut.Lock()
+	h := s.changesetHeader(cs)
+	s.mut.Unlock()
+
+	encode := func(h ChangesetHeader, changes []Change) []byte {
+		buf := new(bytes.Buffer)
+		e, err := NewEncoder(buf, h)
+		require.NoError(t, err)
+		for _, ch := range changes {
+			require.NoError(t, e.Encode(ch))
+		}
+		require.NoError(t, e.Close())
+		return buf.Bytes()
+	}
+	_, err := decodeChangeset(bytes.NewReader(encode(h, cs.Changes)))
+	require.NoError(t, err)
+
+	// Missing change.
+	changes := append([]Change{cs.Changes[0]}, cs.Changes[2:]...)
+	_, err = decodeChangeset(bytes.NewReader(encode(h, changes)))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// Different value.
+	changes = append([]Change{}, cs.Changes...)
+	changes[1].Value = []byte("other")
+	_, err = decodeChangeset(bytes.NewReader(encode(h, changes)))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// Base state not matching base checksum.
+	bad := h
+	bad.BaseState = make([]byte, len(h.BaseState))
+	_, err = decodeChangeset(bytes.NewReader(encode(bad, cs.Changes)))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// Wrong change checksum.
+	bad = h
+	bad.Change = bad.Result
+	_, err = decodeChangeset(bytes.NewReader(encode(bad, cs.Changes)))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestChangesetEncoderOrder(t *testing.T) {
+	e, err := NewEncoder(io.Discard, ChangesetHeader{})
+	require.NoError(t, err)
+	require.NoError(t, e.Encode(Change{Key: []byte("b")}))
+	require.True(t, errors.Is(e.Encode(Change{Key: []byte("a")}), ErrInvalidChangeset))
+	require.True(t, errors.Is(e.Encode(Change{Key: []byte("b")}), ErrInvalidChangeset))
+	require.NoError(t, e.Encode(Change{Key: []byte("c")}))
+	require.NoError(t, e.Close())
+	require.Error(t, e.Encode(Change{Key: []byte("d")}))
+}
+
+func TestChangesetEncoderTooLong(t *testing.T) {
+	big := make([]byte, maxChangeFieldLen+1)
+	_, err := NewEncoder(io.Discard, ChangesetHeader{BaseState: big})
+	require.True(t, errors.Is(err, ErrInvalidChangeset))
+
+	e, err := NewEncoder(io.Discard, ChangesetHeader{})
+	require.NoError(t, err)
+	for _, c := range []Change{{Key: big}, {Key: []byte("a"), Value: big}, {Key: []byte("a"), Prev: big}} {
+		require.True(t, errors.Is(e.Encode(c), ErrInvalidChangeset))
+	}
+	// Nothing is written, so the encoder is still usable.
+	require.NoError(t, e.Encode(Change{Key: []byte("a")}))
+	require.NoError(t, e.Close())
+}
+
+func TestChangesetCodecStream(t *testing.T) {
+	const n = 10000
+	s := NewMemCachedStore(NewMemoryStore())
+	for i := 0; i < n; i++ {
+		require.NoError(t, s.Put(stKey(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%d", i))))
+	}
+	src := new(bytes.Buffer)
+	require.NoError(t, s.EncodeChangeset(src))
+
+	// Relay the stream change by change through a pipe.
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- func() error {
+			d, err := NewDecoder(src)
+			if err != nil {
+				return err
+			}
+			e, err := NewEncoder(pw, d.Header())
+			if err != nil {
+				return err
+			}
+			for {
+				ch, err := d.Decode()
+				if err == io.EOF {
+					return e.Close()
+				}
+				if err != nil {
+					return err
+				}
+				if err := e.Encode(ch); err != nil {
+					return err
+				}
+			}
+		}()
+		pw.Close()
+	}()
+	d, err := NewDecoder(pr)
+	require.NoError(t, err)
+	var count int
+	for ; ; count++ {
+		_, err := d.Decode()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	require.NoError(t, <-errCh)
+	require.Equal(t, n, count)
+	require.Equal(t, s.Checksum(), d.Header().Result)
+}
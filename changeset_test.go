@@ -0,0 +1,98 @@
+package xorkv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// copyStore puts all key-value pairs from src into dst.
+func copyStore(t *testing.T, dst, src Store) {
+	src.Seek(nil, func(k, v []byte) {
+		require.NoError(t, dst.Put(k, v))
+	})
+}
+
+// getChangeset returns pending changes of the store.
+func getChangeset(t *testing.T, s *MemCachedStore) Changeset {
+	cs, err := s.Changeset()
+	require.NoError(t, err)
+	return cs
+}
+
+func TestChangeset(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+	require.NoError(t, ps.Put(stKey("lower2"), []byte("v2")))
+	require.NoError(t, ps.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	s := NewMemCachedStore(ps)
+	require.Equal(t, 0, len(getChangeset(t, s).Changes))
+
+	require.NoError(t, s.Put(stKey("lower1"), []byte("new1")))
+	require.NoError(t, s.Delete(stKey("lower2")))
+	require.NoError(t, s.Put(stKey("new"), []byte{}))
+	require.NoError(t, s.Delete(stKey("absent")))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{2}))
+
+	cs := getChangeset(t, s)
+	require.Equal(t, []Change{
+		{Key: stKey("absent")},
+		{Key: stKey("lower1"), Value: []byte("new1"), Prev: []byte("v1")},
+		{Key: stKey("lower2"), Prev: []byte("v2")},
+		{Key: stKey("new"), Value: []byte{}},
+		{Key: SYSCurrentBlock.Bytes(), Value: []byte{2}, Prev: []byte{1}},
+	}, cs.Changes)
+	require.True(t, cs.Changes[0].IsDelete())
+	require.False(t, cs.Changes[3].IsDelete())
+
+	replica := NewMemoryStore()
+	copyStore(t, replica, ps)
+	require.NoError(t, cs.Apply(replica))
+	require.Equal(t, s.Checksum(), replica.Checksum())
+
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), replica.Checksum())
+	require.Equal(t, 0, len(getChangeset(t, s).Changes))
+
+	// Replica with different state.
+	replica = NewMemoryStore()
+	require.NoError(t, replica.Put(stKey("lower1"), []byte("v1")))
+	sum := replica.Checksum()
+	err = cs.Apply(replica)
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+	// Nothing is written.
+	require.Equal(t, map[string]string{string(stKey("lower1")): "v1"}, storeContents(replica))
+	require.Equal(t, sum, replica.Checksum())
+
+	// Out-of-scope values are checked too.
+	replica = NewMemoryStore()
+	require.NoError(t, replica.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	contents := storeContents(replica)
+	cs = Changeset{Changes: []Change{
+		{Key: stKey("other"), Value: []byte("v")},
+		{Key: SYSCurrentBlock.Bytes(), Value: []byte{2}},
+	}}
+	err = cs.Apply(replica)
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+	require.Equal(t, contents, storeContents(replica))
+}
+
+func TestChangesetApplyCached(t *testing.T) {
+	opts := []Option{WithAccumulator(new(MuHashAccumulator)), WithScope(FullScope)}
+	ps := NewMemoryStore(opts...)
+	require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+	require.NoError(t, ps.Put([]byte("lower2"), []byte("v2")))
+	s := NewMemCachedStore(ps, opts...)
+	require.NoError(t, s.Put(stKey("lower1"), []byte("new1")))
+	require.NoError(t, s.Delete([]byte("lower2")))
+	require.NoError(t, s.Put([]byte("new"), []byte("new")))
+
+	rps := NewMemoryStore(opts...)
+	copyStore(t, rps, ps)
+	replica := NewMemCachedStore(rps, opts...)
+	require.NoError(t, getChangeset(t, s).Apply(replica))
+	require.Equal(t, s.Checksum(), replica.Checksum())
+	require.Equal(t, s.ChangeChecksum(), replica.ChangeChecksum())
+}
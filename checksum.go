@@ -0,0 +1,261 @@
+package xorkv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checksumFormatVersion is the version of Checksum binary and text formats.
+const checksumFormatVersion = 1
+
+var (
+	// ErrInvalidChecksum is returned when Checksum can't be parsed or decoded.
+	ErrInvalidChecksum = errors.New("invalid checksum")
+	// ErrChecksumMismatch is returned when checksums that are expected to be
+	// equal are not.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// Checksum is a store checksum along with the description of the algorithm
+// used to calculate it, checksums calculated differently are never equal.
+type Checksum struct {
+	Accumulator AccumulatorKind
+	Hash        HashFunc
+	Encoding    ElementEncoding
+	Digest      []byte
+}
+
+// Equals returns true if both checksums have the same digest produced with the
+// same algorithm.
+func (c Checksum) Equals(o Checksum) bool {
+	return c.Compare(o) == 0
+}
+
+// Compare returns an integer comparing two checksums, algorithm description
+// is compared first and then the digest. The result is 0 if c == o, -1 if
+// c < o and +1 if c > o.
+func (c Checksum) Compare(o Checksum) int {
+	switch {
+	case c.Accumulator != o.Accumulator:
+		return cmpByte(byte(c.Accumulator), byte(o.Accumulator))
+	case c.Hash != o.Hash:
+		return cmpByte(byte(c.Hash), byte(o.Hash))
+	case c.Encoding != o.Encoding:
+		return cmpByte(byte(c.Encoding), byte(o.Encoding))
+	}
+	return bytes.Compare(c.Digest, o.Digest)
+}
+
+// cmpByte compares two bytes.
+func cmpByte(a, b byte) int {
+	if a < b {
+		return -1
+	}
+	return 1
+}
+
+// IsZero returns true if the checksum is not set (has no digest).
+func (c Checksum) IsZero() bool {
+	return len(c.Digest) == 0
+}
+
+// Algorithm returns the algorithm description in "accumulator:hash:encoding"
+// form, like "xor:SHA-256:v1".
+func (c Checksum) Algorithm() string {
+	return fmt.Sprintf("%s:%s:v%d", c.Accumulator, c.Hash, c.Encoding)
+}
+
+// String implements the fmt.Stringer interface, it returns algorithm
+// description followed by hex-encoded digest, like "xor:SHA-256:v1:00ff...".
+func (c Checksum) String() string {
+	return c.Algorithm() + ":" + hex.EncodeToString(c.Digest)
+}
+
+// ReversedHex returns digest in Neo-style reversed (little-endian) hex with
+// 0x prefix.
+func (c Checksum) ReversedHex() string {
+	rev := make([]byte, len(c.Digest))
+	for i := range c.Digest {
+		rev[len(rev)-1-i] = c.Digest[i]
+	}
+	return "0x" + hex.EncodeToString(rev)
+}
+
+// Base64 returns base64-encoded digest.
+func (c Checksum) Base64() string {
+	return base64.StdEncoding.EncodeToString(c.Digest)
+}
+
+// ParseChecksum parses Checksum from its String representation.
+func ParseChecksum(s string) (Checksum, error) {
+	var c Checksum
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		return c, ErrInvalidChecksum
+	}
+	digest, err := hex.DecodeString(parts[3])
+	if err != nil || len(digest) > 0xff {
+		return c, ErrInvalidChecksum
+	}
+	c.Digest = digest
+	if c.Accumulator, err = parseAccumulatorKind(parts[0]); err != nil {
+		return c, err
+	}
+	if c.Hash, err = parseHashFunc(parts[1]); err != nil {
+		return c, err
+	}
+	var enc uint8
+	if n, err := fmt.Sscanf(parts[2], "v%d", &enc); err != nil || n != 1 ||
+		parts[2] != fmt.Sprintf("v%d", enc) {
+		return c, ErrInvalidChecksum
+	}
+	c.Encoding = ElementEncoding(enc)
+	return c, nil
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (c Checksum) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (c *Checksum) UnmarshalText(text []byte) error {
+	res, err := ParseChecksum(string(text))
+	if err != nil {
+		return err
+	}
+	*c = res
+	return nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. The format
+// is: format version, accumulator kind, hash function, element encoding,
+// digest length and digest itself.
+func (c Checksum) MarshalBinary() ([]byte, error) {
+	if len(c.Digest) > 0xff {
+		return nil, ErrInvalidChecksum
+	}
+	res := make([]byte, 0, 5+len(c.Digest))
+	res = append(res, checksumFormatVersion, byte(c.Accumulator), byte(c.Hash),
+		byte(c.Encoding), byte(len(c.Digest)))
+	return append(res, c.Digest...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (c *Checksum) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 || data[0] != checksumFormatVersion || len(data) != 5+int(data[4]) {
+		return ErrInvalidChecksum
+	}
+	c.Accumulator = AccumulatorKind(data[1])
+	c.Hash = HashFunc(data[2])
+	c.Encoding = ElementEncoding(data[3])
+	c.Digest = make([]byte, data[4])
+	copy(c.Digest, data[5:])
+	return nil
+}
+
+// PrefixChecksums is a checksum breakdown by KeyPrefix (the first byte of the
+// key), it allows to narrow down checksum mismatches to particular kinds of
+// data.
+type PrefixChecksums struct {
+	opts options
+	accs map[KeyPrefix]Accumulator
+}
+
+// keyPrefix returns KeyPrefix of the key, empty key is treated as having
+// zero prefix.
+func keyPrefix(k string) KeyPrefix {
+	if len(k) == 0 {
+		return 0
+	}
+	return KeyPrefix(k[0])
+}
+
+// newPrefixChecksums returns PrefixChecksums with copies of non-empty
+// accumulators (prefixes can become empty after deletions).
+func newPrefixChecksums(opts options, accs map[KeyPrefix]Accumulator) PrefixChecksums {
+	res := PrefixChecksums{opts: opts, accs: make(map[KeyPrefix]Accumulator, len(accs))}
+	empty := opts.acc.Zero().Sum()
+	for p, acc := range accs {
+		if !bytes.Equal(acc.Sum(), empty) {
+			res.accs[p] = cloneAccumulator(acc)
+		}
+	}
+	return res
+}
+
+// Prefixes returns sorted list of all prefixes having some data.
+func (p PrefixChecksums) Prefixes() []KeyPrefix {
+	res := make([]KeyPrefix, 0, len(p.accs))
+	for k := range p.accs {
+		res = append(res, k)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Get returns the checksum of all key-value pairs with the given prefix.
+func (p PrefixChecksums) Get(k KeyPrefix) Checksum {
+	if acc, ok := p.accs[k]; ok {
+		return p.opts.checksum(acc)
+	}
+	return p.opts.checksum(p.opts.acc.Zero())
+}
+
+// Map returns checksums for all prefixes having some data.
+func (p PrefixChecksums) Map() map[KeyPrefix]Checksum {
+	res := make(map[KeyPrefix]Checksum, len(p.accs))
+	for k, acc := range p.accs {
+		res[k] = p.opts.checksum(acc)
+	}
+	return res
+}
+
+// Total derives the global checksum from per-prefix ones.
+func (p PrefixChecksums) Total() Checksum {
+	return p.opts.checksum(p.total())
+}
+
+// total returns an accumulator combining all per-prefix ones.
+func (p PrefixChecksums) total() Accumulator {
+	acc := p.opts.acc.Zero()
+	for _, a := range p.accs {
+		acc.Combine(a)
+	}
+	return acc
+}
+
+// Diff returns sorted list of prefixes with different checksums in p and o.
+func (p PrefixChecksums) Diff(o PrefixChecksums) []KeyPrefix {
+	var res []KeyPrefix
+	for _, k := range mergePrefixes(p.Prefixes(), o.Prefixes()) {
+		if !p.Get(k).Equals(o.Get(k)) {
+			res = append(res, k)
+		}
+	}
+	return res
+}
+
+// mergePrefixes merges two sorted prefix lists removing duplicates.
+func mergePrefixes(a, b []KeyPrefix) []KeyPrefix {
+	res := make([]KeyPrefix, 0, len(a)+len(b))
+	for len(a) != 0 || len(b) != 0 {
+		switch {
+		case len(b) == 0 || (len(a) != 0 && a[0] < b[0]):
+			res = append(res, a[0])
+			a = a[1:]
+		case len(a) == 0 || b[0] < a[0]:
+			res = append(res, b[0])
+			b = b[1:]
+		default:
+			res = append(res, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return res
+}
@@ -0,0 +1,157 @@
+package xorkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// checksumHistoryPrefix is the prefix of checksum history keys, they're never
+// covered by checksums.
+var checksumHistoryPrefix = SYSStateHistory.Bytes()
+
+// currentBlockKey is the key block height is taken from for checksum history.
+var currentBlockKey = SYSCurrentBlock.Bytes()
+
+// HistoryEntry is the checksum history record for a single block (see
+// WithChecksumHistory).
+type HistoryEntry struct {
+	Height uint32
+	// Checksum is the state checksum after the block is persisted.
+	Checksum Checksum
+	// Change is the checksum of changes made by the block (see
+	// MemCachedStore.ChangeChecksum).
+	Change Checksum
+}
+
+// historyKey returns checksum history key for the given height, heights are
+// big-endian to keep keys ordered.
+func historyKey(height uint32) []byte {
+	return binary.BigEndian.AppendUint32(SYSStateHistory.Bytes(), height)
+}
+
+// historyRange returns the range of checksum history keys for heights from
+// start to end inclusive.
+func historyRange(start, end uint32) KeyRange {
+	r := KeyRange{Prefix: checksumHistoryPrefix, Start: historyKey(start)}
+	if end != math.MaxUint32 {
+		r.End = historyKey(end + 1)
+	}
+	return r
+}
+
+// blockHeight returns block height from SYSCurrentBlock value, that is block
+// hash followed by little-endian uint32 height.
+func blockHeight(v []byte) (uint32, error) {
+	if len(v) < 4 {
+		return 0, fmt.Errorf("invalid %s value %x", SYSCurrentBlock, v)
+	}
+	return binary.LittleEndian.Uint32(v[len(v)-4:]), nil
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. The format
+// is binary state checksum followed by binary change checksum, height is a
+// part of the key.
+func (e HistoryEntry) MarshalBinary() ([]byte, error) {
+	sum, err := e.Checksum.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	change, err := e.Change.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(sum, change...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (e *HistoryEntry) UnmarshalBinary(data []byte) error {
+	if len(data) < 5 || len(data) < 5+int(data[4]) {
+		return ErrInvalidChecksum
+	}
+	n := 5 + int(data[4])
+	if err := e.Checksum.UnmarshalBinary(data[:n]); err != nil {
+		return err
+	}
+	return e.Change.UnmarshalBinary(data[n:])
+}
+
+// addHistory adds the history entry for the pending changes into the batch if
+// they update SYSCurrentBlock and prunes old entries, it's supposed to be
+// called with mutex locked.
+func (s *MemCachedStore) addHistory(batch Batch) error {
+	e, ok := s.cache.get(string(currentBlockKey))
+	if !ok || e.deleted {
+		return nil
+	}
+	height, err := blockHeight(e.val)
+	if err != nil {
+		return err
+	}
+	entry, err := HistoryEntry{
+		Checksum: s.opts.checksum(s.stateSum()),
+		Change:   s.changeChecksum(),
+	}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	batch.Put(historyKey(height), entry)
+	if depth := s.opts.historyDepth; depth != 0 && height >= depth {
+		it := s.ps.NewIterator(historyRange(0, height-depth))
+		defer it.Release()
+		for it.Next() {
+			batch.Delete(bytes.Clone(it.Key()))
+		}
+		return it.Err()
+	}
+	return nil
+}
+
+// historyHeight returns the height of checksum history key.
+func historyHeight(k []byte) (uint32, bool) {
+	if len(k) != len(checksumHistoryPrefix)+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(k[len(checksumHistoryPrefix):]), true
+}
+
+// ChecksumAt returns the state checksum recorded for the given block height
+// (see WithChecksumHistory), ErrKeyNotFound is returned if there is no such
+// record.
+func (s *MemCachedStore) ChecksumAt(height uint32) (Checksum, error) {
+	var e HistoryEntry
+	data, err := s.ps.Get(historyKey(height))
+	if err != nil {
+		return e.Checksum, err
+	}
+	return e.Checksum, e.UnmarshalBinary(data)
+}
+
+// ChecksumHistory returns checksum history entries for heights from start to
+// end inclusive ordered by height.
+func (s *MemCachedStore) ChecksumHistory(start, end uint32) ([]HistoryEntry, error) {
+	if start > end {
+		return nil, nil
+	}
+	var (
+		res []HistoryEntry
+		it  = s.ps.NewIterator(historyRange(start, end))
+	)
+	defer it.Release()
+	for it.Next() {
+		h, ok := historyHeight(it.Key())
+		if !ok {
+			continue
+		}
+		e := HistoryEntry{Height: h}
+		if err := e.UnmarshalBinary(it.Value()); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
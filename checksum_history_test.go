@@ -0,0 +1,122 @@
+package xorkv
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// currentBlockValue returns SYSCurrentBlock value for the given height.
+func currentBlockValue(height uint32) []byte {
+	return binary.LittleEndian.AppendUint32(make([]byte, 32), height)
+}
+
+func TestChecksumHistory(t *testing.T) {
+	opts := []Option{WithChecksumHistory(0), WithScope(FullScope)}
+	ps := NewMemoryStore(opts...)
+	s := NewMemCachedStore(ps, opts...)
+
+	var entries []HistoryEntry
+	for h := uint32(0); h < 5; h++ {
+		require.NoError(t, s.Put(stKey("key"), []byte{byte(h)}))
+		require.NoError(t, s.Put(currentBlockKey, currentBlockValue(h)))
+		entries = append(entries, HistoryEntry{
+			Height:   h,
+			Checksum: s.Checksum(),
+			Change:   s.ChangeChecksum(),
+		})
+		_, err := s.Persist()
+		require.NoError(t, err)
+		// History doesn't affect the checksum.
+		require.Equal(t, ps.Checksum(), s.Checksum())
+	}
+	// No SYSCurrentBlock update, no entry.
+	require.NoError(t, s.Put(stKey("key"), []byte{0xff}))
+	_, err := s.Persist()
+	require.NoError(t, err)
+
+	for _, e := range entries {
+		sum, err := s.ChecksumAt(e.Height)
+		require.NoError(t, err)
+		require.Equal(t, e.Checksum, sum)
+	}
+	_, err = s.ChecksumAt(5)
+	require.Equal(t, ErrKeyNotFound, err)
+
+	res, err := s.ChecksumHistory(1, 3)
+	require.NoError(t, err)
+	require.Equal(t, entries[1:4], res)
+	res, err = s.ChecksumHistory(0, 100)
+	require.NoError(t, err)
+	require.Equal(t, entries, res)
+	res, err = s.ChecksumHistory(10, 100)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(res))
+	res, err = s.ChecksumHistory(3, math.MaxUint32)
+	require.NoError(t, err)
+	require.Equal(t, entries[3:], res)
+	res, err = s.ChecksumHistory(3, 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(res))
+
+	require.NoError(t, s.Put(currentBlockKey, []byte{1}))
+	_, err = s.Persist()
+	require.Error(t, err)
+}
+
+func TestChecksumHistoryPrune(t *testing.T) {
+	opts := []Option{WithChecksumHistory(3)}
+	ps := NewMemoryStore(opts...)
+	s := NewMemCachedStore(ps, opts...)
+	for h := uint32(0); h < 10; h++ {
+		require.NoError(t, s.Put(stKey("key"), []byte{byte(h)}))
+		require.NoError(t, s.Put(currentBlockKey, currentBlockValue(h)))
+		_, err := s.Persist()
+		require.NoError(t, err)
+
+		res, err := s.ChecksumHistory(0, h)
+		require.NoError(t, err)
+		require.Equal(t, int(min(h+1, 3)), len(res))
+		require.Equal(t, h, res[len(res)-1].Height)
+	}
+	_, err := s.ChecksumAt(6)
+	require.Equal(t, ErrKeyNotFound, err)
+	_, err = s.ChecksumAt(7)
+	require.NoError(t, err)
+}
+
+func TestChecksumHistoryRevert(t *testing.T) {
+	opts := []Option{WithChecksumHistory(0), WithUndoRecords()}
+	ps := NewMemoryStore(opts...)
+	s := NewMemCachedStore(ps, opts...)
+	for h := uint32(0); h < 2; h++ {
+		require.NoError(t, s.Put(stKey("key"), []byte{byte(h)}))
+		require.NoError(t, s.Put(currentBlockKey, currentBlockValue(h)))
+		_, err := s.Persist()
+		require.NoError(t, err)
+	}
+	u, err := s.LastUndoRecord()
+	require.NoError(t, err)
+	require.NoError(t, s.Revert(u))
+	_, err = s.ChecksumAt(1)
+	require.Equal(t, ErrKeyNotFound, err)
+	sum, err := s.ChecksumAt(0)
+	require.NoError(t, err)
+	require.Equal(t, s.Checksum(), sum)
+}
+
+func TestHistoryEntryMarshal(t *testing.T) {
+	e := HistoryEntry{
+		Checksum: defaultChecksum([]byte{1, 2, 3}),
+		Change:   Checksum{Accumulator: KindLtHash, Digest: []byte{4}},
+	}
+	data, err := e.MarshalBinary()
+	require.NoError(t, err)
+	var res HistoryEntry
+	require.NoError(t, res.UnmarshalBinary(data))
+	require.Equal(t, e, res)
+	require.Error(t, res.UnmarshalBinary(data[:len(data)-1]))
+	require.Error(t, res.UnmarshalBinary(data[:3]))
+}
@@ -0,0 +1,128 @@
+package xorkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// checksumRecordKey is the key MemCachedStore persists its checksum state
+// under, it's never covered by checksums itself.
+var checksumRecordKey = SYSStateChecksum.Bytes()
+
+// sameAlgorithm checks whether checksums calculated with o and other options
+// are comparable.
+func (o *options) sameAlgorithm(other options) bool {
+	return o.acc.Kind() == other.acc.Kind() && o.hash == other.hash && o.enc == other.enc
+}
+
+// sameScope checks whether checksums calculated with o and other options
+// cover the same keys, custom scopes are never considered to be the same.
+func (o *options) sameScope(other options) bool {
+	return o.scopeID != nil && bytes.Equal(o.scopeID, other.scopeID)
+}
+
+// calcPrefixChecksums calculates per-prefix checksums of all key-value pairs
+// in scope iterating over the store, so unlike Store.ChecksumByPrefix it never
+// relies on checksums tracked by the store itself.
+func calcPrefixChecksums(st Store, opts options) (PrefixChecksums, error) {
+	res := PrefixChecksums{opts: opts, accs: make(map[KeyPrefix]Accumulator)}
+	it := st.NewIterator(KeyRange{})
+	defer it.Release()
+	for it.Next() {
+		if !opts.inScope(it.Key()) {
+			continue
+		}
+		k := string(it.Key())
+		p := keyPrefix(k)
+		if _, ok := res.accs[p]; !ok {
+			res.accs[p] = opts.acc.Zero()
+		}
+		res.accs[p].Add(opts.hashKV(k, it.Value()))
+	}
+	return res, it.Err()
+}
+
+// encodePrefixChecksums serializes per-prefix accumulator states. The format
+// is empty set Checksum (describing the algorithm), varint number of prefixes,
+// prefix and varint-prefixed accumulator state for every one of them and then
+// varint-prefixed scope identity (empty for custom scopes).
+func encodePrefixChecksums(p PrefixChecksums) ([]byte, error) {
+	header, err := p.opts.checksum(p.opts.acc.Zero()).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := appendUvarint(header, uint64(len(p.accs)))
+	for _, k := range p.Prefixes() {
+		state, err := p.accs[k].MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, byte(k))
+		buf = appendUvarint(buf, uint64(len(state)))
+		buf = append(buf, state...)
+	}
+	buf = appendUvarint(buf, uint64(len(p.opts.scopeID)))
+	buf = append(buf, p.opts.scopeID...)
+	return buf, nil
+}
+
+// decodePrefixChecksums deserializes per-prefix accumulator states encoded by
+// encodePrefixChecksums, the algorithm must match the one set in opts. The
+// scope identity of the result is the one from the record (records without it
+// are treated as made with a custom scope).
+func decodePrefixChecksums(data []byte, opts options) (PrefixChecksums, error) {
+	var (
+		res   = PrefixChecksums{opts: opts, accs: make(map[KeyPrefix]Accumulator)}
+		empty = opts.checksum(opts.acc.Zero())
+		c     Checksum
+	)
+	res.opts.scopeID = nil
+	if len(data) < 5 || len(data) < 5+int(data[4]) {
+		return res, ErrInvalidChecksum
+	}
+	hlen := 5 + int(data[4])
+	if err := c.UnmarshalBinary(data[:hlen]); err != nil {
+		return res, err
+	}
+	if !c.Equals(empty) {
+		return res, fmt.Errorf("%w: record has %s, expected %s", ErrChecksumMismatch,
+			c.Algorithm(), empty.Algorithm())
+	}
+	r := bytes.NewReader(data[hlen:])
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return res, ErrInvalidChecksum
+	}
+	for i := uint64(0); i < n; i++ {
+		k, err := r.ReadByte()
+		if err != nil {
+			return res, ErrInvalidChecksum
+		}
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return res, ErrInvalidChecksum
+		}
+		state := make([]byte, l)
+		_, _ = r.Read(state)
+		acc := opts.acc.Zero()
+		if err := acc.UnmarshalBinary(state); err != nil {
+			return res, err
+		}
+		res.accs[KeyPrefix(k)] = acc
+	}
+	if r.Len() != 0 {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return res, ErrInvalidChecksum
+		}
+		if l != 0 {
+			res.opts.scopeID = make([]byte, l)
+			_, _ = r.Read(res.opts.scopeID)
+		}
+	}
+	if r.Len() != 0 {
+		return res, ErrInvalidChecksum
+	}
+	return res, nil
+}
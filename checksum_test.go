@@ -0,0 +1,130 @@
+package xorkv
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChecksumEquals(t *testing.T) {
+	a := Checksum{Digest: []byte{1, 2, 3}}
+	require.True(t, a.Equals(Checksum{Digest: []byte{1, 2, 3}}))
+	require.False(t, a.Equals(Checksum{Digest: []byte{1, 2}}))
+	require.False(t, a.Equals(Checksum{Hash: SHA3, Digest: []byte{1, 2, 3}}))
+}
+
+func TestChecksumCompare(t *testing.T) {
+	a := Checksum{Digest: []byte{1, 2, 3}}
+	require.Equal(t, 0, a.Compare(a))
+	require.Equal(t, -1, a.Compare(Checksum{Digest: []byte{1, 2, 4}}))
+	require.Equal(t, 1, a.Compare(Checksum{Digest: []byte{1, 2}}))
+	require.Equal(t, -1, a.Compare(Checksum{Accumulator: KindLtHash}))
+	require.Equal(t, 1, Checksum{Hash: SHA3}.Compare(a))
+	require.Equal(t, 1, Checksum{Encoding: ElementEncodingV1}.Compare(a))
+
+	require.True(t, Checksum{}.IsZero())
+	require.False(t, a.IsZero())
+}
+
+func TestChecksumString(t *testing.T) {
+	c := Checksum{
+		Accumulator: KindMuHash,
+		Hash:        SHA512t256,
+		Encoding:    ElementEncodingV1,
+		Digest:      []byte{0x01, 0x02, 0xff},
+	}
+	require.Equal(t, "muhash:SHA-512/256:v1", c.Algorithm())
+	require.Equal(t, "muhash:SHA-512/256:v1:0102ff", c.String())
+	require.Equal(t, "0xff0201", c.ReversedHex())
+	require.Equal(t, "AQL/", c.Base64())
+
+	p, err := ParseChecksum(c.String())
+	require.NoError(t, err)
+	require.Equal(t, c, p)
+
+	for _, s := range []string{
+		"",
+		"xor:SHA-256:v1",
+		"foo:SHA-256:v1:00",
+		"xor:SHA-1:v1:00",
+		"xor:SHA-256:1:00",
+		"xor:SHA-256:v1x:00",
+		"xor:SHA-256:v1:0",
+		"unknown:SHA-256:v1:00",
+		"xor:SHA-256:v1:00:00",
+	} {
+		_, err := ParseChecksum(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestChecksumMarshal(t *testing.T) {
+	ps := NewMemoryStore(WithAccumulator(new(ECMHAccumulator)), WithHashFunc(SHA3))
+	require.NoError(t, ps.Put(stKey("key"), []byte("value")))
+	c := ps.Checksum()
+
+	data, err := c.MarshalBinary()
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, byte(KindECMH), byte(SHA3), 1, 33}, data[:5])
+	var b Checksum
+	require.NoError(t, b.UnmarshalBinary(data))
+	require.Equal(t, c, b)
+	require.Error(t, b.UnmarshalBinary(data[:len(data)-1]))
+	require.Error(t, b.UnmarshalBinary(data[:4]))
+	data[0] = 0
+	require.Error(t, b.UnmarshalBinary(data))
+	_, err = Checksum{Digest: make([]byte, 256)}.MarshalBinary()
+	require.Error(t, err)
+
+	type record struct {
+		Sum Checksum `json:"sum"`
+	}
+	js, err := json.Marshal(record{c})
+	require.NoError(t, err)
+	require.Equal(t, `{"sum":"`+c.String()+`"}`, string(js))
+	var r record
+	require.NoError(t, json.Unmarshal(js, &r))
+	require.Equal(t, c, r.Sum)
+	require.Error(t, json.Unmarshal([]byte(`{"sum":"xor"}`), &r))
+}
+
+func TestMergePrefixes(t *testing.T) {
+	require.Equal(t, []KeyPrefix{}, mergePrefixes(nil, nil))
+	require.Equal(t, []KeyPrefix{STAccount, STCoin, STStorage},
+		mergePrefixes([]KeyPrefix{STAccount, STStorage}, []KeyPrefix{STCoin, STStorage}))
+	require.Equal(t, []KeyPrefix{STAccount}, mergePrefixes(nil, []KeyPrefix{STAccount}))
+}
+
+func TestChecksumByPrefix(t *testing.T) {
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator)} {
+		ps := NewMemoryStore(WithAccumulator(acc), WithScope(FullScope))
+		s := NewMemCachedStore(ps, WithAccumulator(acc), WithScope(FullScope))
+
+		require.NoError(t, s.Put(AppendPrefix(STAccount, []byte("acc1")), []byte("1")))
+		require.NoError(t, s.Put(AppendPrefix(STAccount, []byte("acc2")), []byte("2")))
+		require.NoError(t, s.Put(AppendPrefix(STStorage, []byte("key")), []byte("value")))
+		require.NoError(t, s.Put(AppendPrefix(STCoin, []byte("coin")), []byte("value")))
+		require.NoError(t, s.Delete(AppendPrefix(STCoin, []byte("coin"))))
+		require.NoError(t, s.Put([]byte{}, []byte("empty")))
+
+		sums := s.ChecksumByPrefix()
+		require.Equal(t, []KeyPrefix{0, STAccount, STStorage}, sums.Prefixes())
+		require.Equal(t, s.Checksum(), sums.Total())
+		require.Equal(t, 3, len(sums.Map()))
+		require.Equal(t, acc.Zero().Sum(), sums.Get(STCoin).Digest)
+
+		_, err := s.Persist()
+		require.NoError(t, err)
+		psums := ps.ChecksumByPrefix()
+		require.Equal(t, sums.Map(), psums.Map())
+		require.Equal(t, ps.Checksum(), psums.Total())
+		require.Nil(t, sums.Diff(psums))
+
+		// Change only the contract storage.
+		require.NoError(t, s.Put(AppendPrefix(STStorage, []byte("key")), []byte("other")))
+		require.NoError(t, s.Put(AppendPrefix(STValidator, []byte("val")), []byte("value")))
+		require.Equal(t, []KeyPrefix{STValidator, STStorage}, s.ChecksumByPrefix().Diff(psums))
+		require.Equal(t, s.Checksum(), s.ChecksumByPrefix().Total())
+	}
+}
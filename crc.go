@@ -0,0 +1,108 @@
+package xorkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// crcTable is used for changeset stream and file store log integrity checks.
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// crcReader reads varint-based formats calculating CRC-32C of all the data
+// read except CRCs themselves.
+type crcReader struct {
+	r   *bufio.Reader
+	crc uint32
+	// n is the number of bytes read.
+	n int64
+	// invalid is the error format errors and unexpected end of data are
+	// reported with.
+	invalid error
+}
+
+// newCRCReader creates a crcReader reporting format errors with the given
+// error.
+func newCRCReader(r io.Reader, invalid error) *crcReader {
+	return &crcReader{r: bufio.NewReader(r), invalid: invalid}
+}
+
+// readByte reads a single byte updating CRC.
+func (c *crcReader) readByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return 0, c.readErr(err)
+	}
+	c.n++
+	c.crc = crc32.Update(c.crc, crcTable, []byte{b})
+	return b, nil
+}
+
+// read fills buf with data updating CRC.
+func (c *crcReader) read(buf []byte) error {
+	n, err := io.ReadFull(c.r, buf)
+	c.n += int64(n)
+	if err != nil {
+		return c.readErr(err)
+	}
+	c.crc = crc32.Update(c.crc, crcTable, buf)
+	return nil
+}
+
+// readUvarint reads varint updating CRC.
+func (c *crcReader) readUvarint() (uint64, error) {
+	var x uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := c.readByte()
+		if err != nil {
+			return 0, err
+		}
+		if i == binary.MaxVarintLen64-1 && b > 1 {
+			break
+		}
+		x |= uint64(b&0x7f) << (7 * i)
+		if b < 0x80 {
+			return x, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: varint overflow", c.invalid)
+}
+
+// readBytes reads varint-prefixed byte slice that can't be longer than max,
+// so that corrupted lengths can't make it allocate arbitrary amounts of
+// memory.
+func (c *crcReader) readBytes(max uint64) ([]byte, error) {
+	l, err := c.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if l > max {
+		return nil, fmt.Errorf("%w: field is too long (%d)", c.invalid, l)
+	}
+	buf := make([]byte, l)
+	return buf, c.read(buf)
+}
+
+// checkCRC reads little-endian CRC and compares it with the calculated one.
+func (c *crcReader) checkCRC() error {
+	var buf [4]byte
+	n, err := io.ReadFull(c.r, buf[:])
+	c.n += int64(n)
+	if err != nil {
+		return c.readErr(err)
+	}
+	if binary.LittleEndian.Uint32(buf[:]) != c.crc {
+		return fmt.Errorf("%w: CRC mismatch", c.invalid)
+	}
+	return nil
+}
+
+// readErr converts read error, end of data is reported as format error.
+func (c *crcReader) readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", c.invalid, io.ErrUnexpectedEOF)
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package xorkv
+
+import (
+	"fmt"
+)
+
+// Delta returns the checksum of pending changes defined as a set of removed
+// previous and added new key-value pairs, so that combining it with the lower
+// store checksum gives Checksum (for XorAccumulator it's just XOR of them, see
+// Checksum.Combine). Unlike ChangeChecksum it uses the same element hashes as
+// the store checksum does.
+func (s *MemCachedStore) Delta() Checksum {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	acc := s.opts.acc.Zero()
+	s.cache.ascend(func(e kvEntry) {
+		// Previous values of keys in scope are always known, others are
+		// skipped by addDelta.
+		ch := Change{Key: []byte(e.key), Prev: s.orig[e.key]}
+		if !e.deleted {
+			ch.Value = e.val
+		}
+		ch.addDelta(acc, s.opts, false)
+	})
+	return s.opts.checksum(acc)
+}
+
+// Combine returns the checksum c updated with the delta (see
+// MemCachedStore.Delta). It's only possible for accumulators which digest is
+// their whole state, that is XorAccumulator and ECMHAccumulator, others need
+// accumulator states to be combined.
+func (c Checksum) Combine(delta Checksum) (Checksum, error) {
+	if c.Algorithm() != delta.Algorithm() {
+		return c, fmt.Errorf("%w: can't combine %s with %s", ErrChecksumMismatch,
+			c.Algorithm(), delta.Algorithm())
+	}
+	var a, d Accumulator
+	switch c.Accumulator {
+	case KindXor:
+		a, d = new(XorAccumulator), new(XorAccumulator)
+	case KindECMH:
+		a, d = new(ECMHAccumulator), new(ECMHAccumulator)
+	default:
+		return c, fmt.Errorf("%w: %s digests can't be combined", ErrInvalidChecksum,
+			c.Accumulator)
+	}
+	if a.UnmarshalBinary(c.Digest) != nil || d.UnmarshalBinary(delta.Digest) != nil {
+		return c, ErrInvalidChecksum
+	}
+	a.Combine(d)
+	c.Digest = a.Sum()
+	return c, nil
+}
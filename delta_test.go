@@ -0,0 +1,138 @@
+package xorkv
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// requireDeltaIdentity checks that the lower store checksum combined with the
+// delta is equal to the cached store checksum.
+func requireDeltaIdentity(t *testing.T, s *MemCachedStore, msgAndArgs ...interface{}) {
+	var (
+		delta    = s.Delta()
+		expected = s.Checksum()
+	)
+	switch expected.Accumulator {
+	case KindXor:
+		// Plain XOR of digests.
+		lower := s.ps.Checksum()
+		for i := range lower.Digest {
+			lower.Digest[i] ^= delta.Digest[i]
+		}
+		require.Equal(t, expected, lower, msgAndArgs...)
+		fallthrough
+	case KindECMH:
+		res, err := s.ps.Checksum().Combine(delta)
+		require.NoError(t, err, msgAndArgs...)
+		require.Equal(t, expected, res, msgAndArgs...)
+	}
+	// Other accumulators can only be combined as states.
+	acc := getChangeset(t, s).delta(s.opts)
+	lower := s.ps.ChecksumByPrefix()
+	total := lower.total()
+	total.Combine(acc)
+	require.Equal(t, delta, lower.opts.checksum(acc), msgAndArgs...)
+	require.Equal(t, expected, lower.opts.checksum(total), msgAndArgs...)
+}
+
+func TestDeltaModel(t *testing.T) {
+	var (
+		keys = [][]byte{stKey("a"), stKey("b"), stKey("c"), stKey("d"),
+			AppendPrefix(STAccount, []byte("a")), AppendPrefixInt(DataBlock, 1),
+			SYSCurrentBlock.Bytes()}
+		values = [][]byte{{}, []byte("1"), []byte("2"), []byte("3")}
+	)
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator),
+		new(MuHashAccumulator), new(ECMHAccumulator)} {
+		t.Run(acc.Kind().String(), func(t *testing.T) {
+			var (
+				r  = rand.New(rand.NewSource(42))
+				ps = NewMemoryStore(WithAccumulator(acc))
+			)
+			for _, k := range keys[:4] {
+				require.NoError(t, ps.Put(k, values[1]))
+			}
+			s := NewMemCachedStore(ps, WithAccumulator(acc))
+			requireDeltaIdentity(t, s)
+			for i := 0; i < 300; i++ {
+				switch op := r.Intn(10); {
+				case op < 4:
+					k, v := keys[r.Intn(len(keys))], values[r.Intn(len(values))]
+					require.NoError(t, s.Put(k, v))
+				case op < 7:
+					require.NoError(t, s.Delete(keys[r.Intn(len(keys))]))
+				case op < 8:
+					b := s.Batch()
+					for j := r.Intn(5); j >= 0; j-- {
+						k, v := keys[r.Intn(len(keys))], values[r.Intn(len(values))]
+						if r.Intn(2) == 0 {
+							b.Put(k, v)
+						} else {
+							b.Delete(k)
+						}
+					}
+					require.NoError(t, s.PutBatch(b))
+				case op < 9 && r.Intn(4) == 0:
+					s.Discard()
+				default:
+					_, err := s.Persist()
+					require.NoError(t, err)
+					require.Equal(t, ps.Checksum(), s.Checksum())
+				}
+				requireDeltaIdentity(t, s, "op %d", i)
+			}
+		})
+	}
+}
+
+func TestDeltaChangeChecksum(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("key"), []byte("value")))
+	s := NewMemCachedStore(ps)
+	empty := s.opts.checksum(s.opts.acc.Zero())
+	require.Equal(t, empty, s.Delta())
+	require.Equal(t, empty, s.ChangeChecksum())
+
+	// Deletion delta is the hash of the deleted pair, while change checksum
+	// uses key hash, so it can't be combined with the lower checksum.
+	require.NoError(t, s.Delete(stKey("key")))
+	res, err := ps.Checksum().Combine(s.Delta())
+	require.NoError(t, err)
+	require.Equal(t, s.Checksum(), res)
+	require.Equal(t, empty, s.Checksum())
+	res, err = ps.Checksum().Combine(s.ChangeChecksum())
+	require.NoError(t, err)
+	require.NotEqual(t, s.Checksum(), res)
+
+	// Put of the same value is not a change.
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.Equal(t, empty, s.Delta())
+	require.NotEqual(t, empty, s.ChangeChecksum())
+}
+
+func TestChecksumCombine(t *testing.T) {
+	els := testElements(2)
+	for _, acc := range []Accumulator{new(XorAccumulator), new(ECMHAccumulator)} {
+		o := newOptions([]Option{WithAccumulator(acc)})
+		a, d, all := acc.Zero(), acc.Zero(), acc.Zero()
+		a.Add(els[0])
+		d.Add(els[1])
+		all.Add(els[0])
+		all.Add(els[1])
+		res, err := o.checksum(a).Combine(o.checksum(d))
+		require.NoError(t, err)
+		require.Equal(t, o.checksum(all), res)
+	}
+
+	xor := newOptions(nil)
+	_, err := xor.checksum(new(XorAccumulator)).Combine(xor.checksum(new(ECMHAccumulator)))
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+	lt := xor.checksum(new(LtHashAccumulator))
+	_, err = lt.Combine(lt)
+	require.True(t, errors.Is(err, ErrInvalidChecksum))
+	_, err = Checksum{Digest: []byte{1}}.Combine(Checksum{Digest: []byte{1}})
+	require.True(t, errors.Is(err, ErrInvalidChecksum))
+}
@@ -0,0 +1,135 @@
+package xorkv
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// ecmhDigestSize is the size of compressed P-256 point.
+const ecmhDigestSize = 33
+
+// ECMHAccumulator is an elliptic curve multiset hash Accumulator. Every
+// element is mapped to a point on P-256 curve (using try-and-increment
+// method) and the set is represented by the sum of all its points, removals
+// add the negated point. The digest is a 33-byte compressed point with the
+// point at infinity (empty set) represented by all zeroes. The zero value is
+// an empty set ready to use.
+type ECMHAccumulator struct {
+	// x and y are affine point coordinates with (0, 0) being the point at
+	// infinity (as in crypto/elliptic).
+	x *big.Int
+	y *big.Int
+}
+
+// ecmhPoint maps the element to a curve point.
+func ecmhPoint(e Uint256) (*big.Int, *big.Int) {
+	var (
+		params = elliptic.P256().Params()
+		three  = big.NewInt(3)
+		buf    = make([]byte, len(e)+4)
+	)
+	copy(buf, e[:])
+	for ctr := uint32(0); ; ctr++ {
+		binary.LittleEndian.PutUint32(buf[len(e):], ctr)
+		h := sha256.Sum256(buf)
+		x := new(big.Int).SetBytes(h[:])
+		x.Mod(x, params.P)
+
+		// y² = x³ - 3x + b.
+		rhs := new(big.Int).Exp(x, three, params.P)
+		rhs.Sub(rhs, new(big.Int).Mul(x, three))
+		rhs.Add(rhs, params.B)
+		rhs.Mod(rhs, params.P)
+		y := new(big.Int).ModSqrt(rhs, params.P)
+		if y == nil {
+			continue
+		}
+		// Choose the even root for the mapping to be deterministic.
+		if y.Bit(0) == 1 {
+			y.Sub(params.P, y)
+		}
+		return x, y
+	}
+}
+
+// init makes the zero value usable.
+func (a *ECMHAccumulator) init() {
+	if a.x == nil {
+		a.x = new(big.Int)
+		a.y = new(big.Int)
+	}
+}
+
+// Add implements the Accumulator interface.
+func (a *ECMHAccumulator) Add(e Uint256) {
+	a.init()
+	x, y := ecmhPoint(e)
+	a.x, a.y = elliptic.P256().Add(a.x, a.y, x, y)
+}
+
+// Remove implements the Accumulator interface.
+func (a *ECMHAccumulator) Remove(e Uint256) {
+	a.init()
+	x, y := ecmhPoint(e)
+	y.Sub(elliptic.P256().Params().P, y)
+	a.x, a.y = elliptic.P256().Add(a.x, a.y, x, y)
+}
+
+// Combine implements the Accumulator interface.
+func (a *ECMHAccumulator) Combine(o Accumulator) {
+	v := o.(*ECMHAccumulator)
+	if v.x == nil {
+		return
+	}
+	a.init()
+	a.x, a.y = elliptic.P256().Add(a.x, a.y, v.x, v.y)
+}
+
+// Sum implements the Accumulator interface, it returns compressed point.
+func (a *ECMHAccumulator) Sum() []byte {
+	a.init()
+	if a.x.Sign() == 0 && a.y.Sign() == 0 {
+		return make([]byte, ecmhDigestSize)
+	}
+	return elliptic.MarshalCompressed(elliptic.P256(), a.x, a.y)
+}
+
+// Zero implements the Accumulator interface.
+func (a *ECMHAccumulator) Zero() Accumulator {
+	return new(ECMHAccumulator)
+}
+
+// Kind implements the Accumulator interface.
+func (a *ECMHAccumulator) Kind() AccumulatorKind {
+	return KindECMH
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface, the state
+// is the same compressed point returned by Sum.
+func (a *ECMHAccumulator) MarshalBinary() ([]byte, error) {
+	return a.Sum(), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (a *ECMHAccumulator) UnmarshalBinary(data []byte) error {
+	if len(data) != ecmhDigestSize {
+		return ErrInvalidAccumulatorState
+	}
+	if data[0] == 0 {
+		for _, b := range data[1:] {
+			if b != 0 {
+				return ErrInvalidAccumulatorState
+			}
+		}
+		a.x, a.y = new(big.Int), new(big.Int)
+		return nil
+	}
+	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
+	if x == nil {
+		return ErrInvalidAccumulatorState
+	}
+	a.x, a.y = x, y
+	return nil
+}
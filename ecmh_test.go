@@ -0,0 +1,38 @@
+package xorkv
+
+import (
+	"crypto/elliptic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestECMHPoint(t *testing.T) {
+	for _, e := range testElements(32) {
+		x, y := ecmhPoint(e)
+		require.True(t, elliptic.P256().IsOnCurve(x, y))
+		require.Equal(t, uint(0), y.Bit(0))
+	}
+}
+
+func TestECMHDigest(t *testing.T) {
+	var a ECMHAccumulator
+	require.Equal(t, make([]byte, ecmhDigestSize), a.Sum())
+
+	e := testElements(1)[0]
+	a.Add(e)
+	d := a.Sum()
+	require.Equal(t, ecmhDigestSize, len(d))
+	require.Equal(t, byte(2), d[0])
+
+	a.Remove(e)
+	require.Equal(t, make([]byte, ecmhDigestSize), a.Sum())
+
+	// Invalid infinity encoding.
+	d[0] = 0
+	require.Error(t, a.UnmarshalBinary(d))
+}
+
+func TestECMHStores(t *testing.T) {
+	testStoresAccumulatorConsistency(t, new(ECMHAccumulator))
+}
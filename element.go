@@ -0,0 +1,54 @@
+package xorkv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// ElementEncoding is a way to serialize key-value pair for hashing it into
+// a checksum element.
+type ElementEncoding byte
+
+// ElementEncoding values.
+const (
+	// ElementEncodingLegacy is just a concatenation of key and value, it's
+	// ambiguous ("ab"+"c" is the same as "a"+"bc") and is only kept to be
+	// able to reproduce old checksums.
+	ElementEncodingLegacy ElementEncoding = 0
+	// ElementEncodingV1 is domain separation tag followed by version byte,
+	// varint-prefixed key and varint-prefixed value.
+	ElementEncodingV1 ElementEncoding = 1
+)
+
+// elementDomainTag is prepended to versioned element encodings.
+const elementDomainTag = "xorkv/kv"
+
+// Encode returns serialized key-value pair.
+func (e ElementEncoding) Encode(k string, v []byte) []byte {
+	switch e {
+	case ElementEncodingLegacy:
+		return append([]byte(k), v...)
+	case ElementEncodingV1:
+		buf := make([]byte, 0, len(elementDomainTag)+1+2*binary.MaxVarintLen64+len(k)+len(v))
+		buf = append(buf, elementDomainTag...)
+		buf = append(buf, byte(e))
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(v)))
+		return append(buf, v...)
+	default:
+		panic("unknown element encoding")
+	}
+}
+
+// HashKV returns a hash of key-value pair serialized with this encoding.
+func (e ElementEncoding) HashKV(k string, v []byte) Uint256 {
+	return sha256.Sum256(e.Encode(k, v))
+}
+
+// appendUvarint appends varint-encoded n to buf.
+func appendUvarint(buf []byte, n uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	l := binary.PutUvarint(tmp[:], n)
+	return append(buf, tmp[:l]...)
+}
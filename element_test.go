@@ -0,0 +1,33 @@
+package xorkv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestElementEncodingV1(t *testing.T) {
+	ref := append([]byte("xorkv/kv"), 1, 3, 'k', 'e', 'y', 5, 'v', 'a', 'l', 'u', 'e')
+	require.Equal(t, ref, ElementEncodingV1.Encode("key", []byte("value")))
+
+	// No ambiguity on key/value boundary.
+	require.NotEqual(t, ElementEncodingV1.HashKV("ab", []byte("c")),
+		ElementEncodingV1.HashKV("a", []byte("bc")))
+	require.Equal(t, ElementEncodingLegacy.HashKV("ab", []byte("c")),
+		ElementEncodingLegacy.HashKV("a", []byte("bc")))
+}
+
+func TestElementEncodingUnknown(t *testing.T) {
+	require.Panics(t, func() { ElementEncoding(0xff).Encode("k", nil) })
+}
+
+func TestStoresLegacyEncoding(t *testing.T) {
+	ps := NewMemoryStore(WithElementEncoding(ElementEncodingLegacy))
+	s := NewMemCachedStore(ps, WithElementEncoding(ElementEncodingLegacy))
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	ref := LegacyHashKV(string(stKey("key")), []byte("value"))
+	require.Equal(t, Checksum{Digest: ref[:]}, s.Checksum())
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, Checksum{Digest: ref[:]}, ps.Checksum())
+}
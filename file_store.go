@@ -0,0 +1,820 @@
+package xorkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// File store log format constants.
+const (
+	fileMagic      = "XKVF"
+	fileVersion    = 1
+	fileSegmentExt = ".log"
+	// fileTempExt is appended to segment name while it's being compacted.
+	fileTempExt = ".tmp"
+	// maxFileFieldLen limits key, value and checksum record sizes.
+	maxFileFieldLen = 1 << 26
+)
+
+// File store log entry types.
+const (
+	entryPut    byte = 1
+	entryDelete byte = 2
+	// entryCommit ends every batch, it contains the checksum record and is
+	// followed by CRC-32C of the whole log up to this point.
+	entryCommit byte = 3
+)
+
+var (
+	// ErrInvalidLog is returned when FileStore log can't be read.
+	ErrInvalidLog = errors.New("invalid file store log")
+	// ErrFieldTooLong is returned when FileStore key, value or checksum
+	// record is too long to be written into the log.
+	ErrFieldTooLong = errors.New("field is too long")
+)
+
+// FileStore is a persistent Store keeping all data in an append-only log
+// segment file with in-memory index of keys rebuilt when the store is opened.
+// The log is a header (magic and version) followed by batches of put and
+// delete entries, every batch (even a single Put) ends with a commit entry
+// containing the checksum state and CRC of the log, so batches are applied
+// atomically: incomplete batch left at the end of the log by an interrupted
+// write is dropped when it's opened, while damaged data followed by anything
+// else is an error. Data is synced to disk only with WithSync option.
+// Overwritten and deleted entries stay in the log until it's compacted (see
+// Compact).
+type FileStore struct {
+	mut sync.RWMutex
+	dir string
+	// seg is the number of the current segment.
+	seg uint64
+	f   *os.File
+	// size is the size of valid log data.
+	size int64
+	// crc is the log CRC up to size (excluding stored CRCs).
+	crc   uint32
+	index map[string]fileValue
+	sums  map[KeyPrefix]Accumulator
+	// err is set when the log is in unknown state after failed write.
+	err error
+	// iters counts active iterators per segment file, old segment files are
+	// closed after compaction when all of their iterators are released.
+	iters map[*os.File]int
+
+	opts options
+}
+
+// fileValue is the location of a value in the log.
+type fileValue struct {
+	off int64
+	len int
+}
+
+// segmentName returns the segment file name.
+func segmentName(seg uint64) string {
+	return fmt.Sprintf("%08d%s", seg, fileSegmentExt)
+}
+
+// listSegments returns sorted numbers of segment files in the directory.
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, fileSegmentExt) {
+			continue
+		}
+		seg, err := strconv.ParseUint(strings.TrimSuffix(name, fileSegmentExt), 10, 64)
+		if err == nil {
+			res = append(res, seg)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// OpenFileStore opens (or creates) FileStore in the given directory. The log
+// is replayed to rebuild key index and the checksum is taken from the last
+// batch, it's recalculated if it was calculated with different options (that
+// includes any custom Scope, see WithScope) or if WithVerifyRecord is used
+// (then it's an error for them to differ).
+func OpenFileStore(dir string, opts ...Option) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	segs, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		dir:   dir,
+		seg:   1,
+		index: make(map[string]fileValue),
+		sums:  make(map[KeyPrefix]Accumulator),
+		iters: make(map[*os.File]int),
+		opts:  newOptions(opts),
+	}
+	if len(segs) != 0 {
+		s.seg = segs[len(segs)-1]
+	}
+	if err := removeStale(dir, segs); err != nil {
+		return nil, err
+	}
+	s.f, err = os.OpenFile(filepath.Join(dir, segmentName(s.seg)), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		_ = s.f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// removeStale removes segments left after interrupted compaction: unfinished
+// temporary files and old segments that were replaced by the last one.
+func removeStale(dir string, segs []uint64) error {
+	for i := 0; i < len(segs)-1; i++ {
+		if err := os.Remove(filepath.Join(dir, segmentName(segs[i]))); err != nil {
+			return err
+		}
+	}
+	tmps, err := filepath.Glob(filepath.Join(dir, "*"+fileSegmentExt+fileTempExt))
+	if err != nil {
+		return err
+	}
+	for _, tmp := range tmps {
+		if err := os.Remove(tmp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load replays the log.
+func (s *FileStore) load() error {
+	st, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if st.Size() == 0 {
+		header := append([]byte(fileMagic), fileVersion)
+		if _, err := s.f.WriteAt(header, 0); err != nil {
+			return err
+		}
+		s.size = int64(len(header))
+		s.crc = crc32.Update(0, crcTable, header)
+		return s.f.Sync()
+	}
+	l, err := replayLog(s.f)
+	if err != nil {
+		return err
+	}
+	if l.size < st.Size() {
+		if err := s.f.Truncate(l.size); err != nil {
+			return err
+		}
+	}
+	s.index, s.size, s.crc = l.index, l.size, l.crc
+	return s.loadChecksum(l.record)
+}
+
+// logState is the result of log replay.
+type logState struct {
+	index map[string]fileValue
+	// record is the checksum record of the last batch.
+	record []byte
+	// size and crc are the size and CRC of valid log data.
+	size int64
+	crc  uint32
+}
+
+// replayLog reads all complete batches from the log file. A damaged batch is
+// only ignored if it runs to the end of the file (that is, there is nothing
+// but zeroes after the point it can't be read at), which is what an
+// interrupted write leaves, otherwise ErrInvalidLog is returned.
+func replayLog(f *os.File) (logState, error) {
+	var (
+		l       = logState{index: make(map[string]fileValue)}
+		r       = newCRCReader(io.NewSectionReader(f, 0, math.MaxInt64), ErrInvalidLog)
+		header  = make([]byte, len(fileMagic)+1)
+		pending []fileEntry
+	)
+	if err := r.read(header); err != nil {
+		return l, err
+	}
+	if string(header[:len(fileMagic)]) != fileMagic || header[len(fileMagic)] != fileVersion {
+		return l, fmt.Errorf("%w: bad header", ErrInvalidLog)
+	}
+	l.size, l.crc = r.n, r.crc
+	for {
+		e, err := readEntry(r)
+		if err != nil {
+			if !errors.Is(err, ErrInvalidLog) {
+				return l, err
+			}
+			torn, terr := zeroTail(f, r.n)
+			if terr != nil {
+				return l, terr
+			}
+			if torn {
+				return l, nil
+			}
+			return l, fmt.Errorf("batch at offset %d is damaged: %w", l.size, err)
+		}
+		if e.typ != entryCommit {
+			pending = append(pending, e)
+			continue
+		}
+		for _, p := range pending {
+			if p.typ == entryDelete {
+				delete(l.index, p.key)
+			} else {
+				l.index[p.key] = p.val
+			}
+		}
+		pending, l.record = pending[:0], e.record
+		l.size, l.crc = r.n, r.crc
+	}
+}
+
+// zeroTail checks whether the file has only zeroes (or nothing) after the
+// given offset.
+func zeroTail(f *os.File, off int64) (bool, error) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.ReadAt(buf, off)
+		for _, b := range buf[:n] {
+			if b != 0 {
+				return false, nil
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// fileEntry is a log entry read from the file.
+type fileEntry struct {
+	typ    byte
+	key    string
+	val    fileValue
+	record []byte
+}
+
+// readEntry reads the next log entry, commit entry CRC is checked.
+func readEntry(r *crcReader) (fileEntry, error) {
+	var e fileEntry
+	typ, err := r.readByte()
+	if err != nil {
+		return e, err
+	}
+	e.typ = typ
+	switch typ {
+	case entryPut, entryDelete:
+		key, err := r.readBytes(maxFileFieldLen)
+		if err != nil {
+			return e, err
+		}
+		e.key = string(key)
+		if typ == entryPut {
+			val, err := r.readBytes(maxFileFieldLen)
+			if err != nil {
+				return e, err
+			}
+			e.val = fileValue{off: r.n - int64(len(val)), len: len(val)}
+		}
+	case entryCommit:
+		if e.record, err = r.readBytes(maxFileFieldLen); err != nil {
+			return e, err
+		}
+		if err := r.checkCRC(); err != nil {
+			return e, err
+		}
+	default:
+		return e, fmt.Errorf("%w: bad entry type %d", ErrInvalidLog, typ)
+	}
+	return e, nil
+}
+
+// loadChecksum initializes checksum from the checksum record of the last
+// batch recalculating it if needed.
+func (s *FileStore) loadChecksum(record []byte) error {
+	var (
+		sums   PrefixChecksums
+		decErr = ErrKeyNotFound
+		err    error
+	)
+	if record != nil {
+		sums, decErr = decodePrefixChecksums(record, s.opts)
+	}
+	// The record is only usable if it covers the same keys.
+	usable := decErr == nil && s.opts.sameScope(sums.opts)
+	if usable && !s.opts.verify {
+		s.sums = sums.accs
+		return nil
+	}
+	if s.sums, err = s.calcSums(s.f, s.index); err != nil {
+		return err
+	}
+	if usable {
+		if diff := sums.Diff(s.checksumByPrefix()); len(diff) != 0 {
+			return fmt.Errorf("%w: log record differs from the data for prefixes %v",
+				ErrChecksumMismatch, diff)
+		}
+	}
+	return nil
+}
+
+// calcSums calculates per-prefix checksums of all values from the index
+// stored in the log file.
+func (s *FileStore) calcSums(f *os.File, index map[string]fileValue) (map[KeyPrefix]Accumulator, error) {
+	sums := make(map[KeyPrefix]Accumulator)
+	for k, v := range index {
+		if !s.opts.inScope([]byte(k)) {
+			continue
+		}
+		val, err := readValue(f, v)
+		if err != nil {
+			return nil, err
+		}
+		s.sum(sums, k).Add(s.opts.hashKV(k, val))
+	}
+	return sums, nil
+}
+
+// sum returns the accumulator for the key's prefix from sums creating it if
+// needed.
+func (s *FileStore) sum(sums map[KeyPrefix]Accumulator, k string) Accumulator {
+	p := keyPrefix(k)
+	acc, ok := sums[p]
+	if !ok {
+		acc = s.opts.acc.Zero()
+		sums[p] = acc
+	}
+	return acc
+}
+
+// readValue reads value from the log.
+func (s *FileStore) readValue(v fileValue) ([]byte, error) {
+	return readValue(s.f, v)
+}
+
+// readValue reads value from the log file.
+func readValue(f *os.File, v fileValue) ([]byte, error) {
+	buf := make([]byte, v.len)
+	if _, err := f.ReadAt(buf, v.off); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Get implements the Store interface.
+func (s *FileStore) Get(key []byte) ([]byte, error) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	v, ok := s.index[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return s.readValue(v)
+}
+
+// Put implements the Store interface.
+func (s *FileStore) Put(key, value []byte) error {
+	return s.write(nil, map[string][]byte{string(key): value})
+}
+
+// Delete implements the Store interface.
+func (s *FileStore) Delete(key []byte) error {
+	return s.write(map[string]bool{string(key): true}, nil)
+}
+
+// Batch implements the Store interface and returns a compatible Batch.
+func (s *FileStore) Batch() Batch {
+	return newMemoryBatch()
+}
+
+// PutBatch implements the Store interface, the batch is written atomically.
+func (s *FileStore) PutBatch(batch Batch) error {
+	b := batch.(*MemoryBatch)
+	return s.write(b.del, b.mem)
+}
+
+// write appends a batch of deletions and puts (that should have different
+// keys) to the log updating the index and the checksum.
+func (s *FileStore) write(del map[string]bool, put map[string][]byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	var (
+		buf  []byte
+		vals = make(map[string]fileValue, len(put))
+		// sums are changed copies of s.sums accumulators.
+		sums = make(map[KeyPrefix]Accumulator)
+		sum  = func(k string) Accumulator {
+			p := keyPrefix(k)
+			if _, ok := sums[p]; !ok {
+				if acc, ok := s.sums[p]; ok {
+					sums[p] = cloneAccumulator(acc)
+				}
+			}
+			return s.sum(sums, k)
+		}
+		remove = func(k string) error {
+			v, ok := s.index[k]
+			if !ok || !s.opts.inScope([]byte(k)) {
+				return nil
+			}
+			old, err := s.readValue(v)
+			if err != nil {
+				return err
+			}
+			sum(k).Remove(s.opts.hashKV(k, old))
+			return nil
+		}
+	)
+	for k := range del {
+		if _, ok := s.index[k]; !ok {
+			continue
+		}
+		if err := remove(k); err != nil {
+			return err
+		}
+		buf = append(buf, entryDelete)
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+	}
+	for k, v := range put {
+		if len(k) > maxFileFieldLen || len(v) > maxFileFieldLen {
+			return fmt.Errorf("%w: %d byte key with %d byte value", ErrFieldTooLong, len(k), len(v))
+		}
+	}
+	for k, v := range put {
+		if err := remove(k); err != nil {
+			return err
+		}
+		if s.opts.inScope([]byte(k)) {
+			sum(k).Add(s.opts.hashKV(k, v))
+		}
+		buf = append(buf, entryPut)
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(v)))
+		vals[k] = fileValue{off: s.size + int64(len(buf)), len: len(v)}
+		buf = append(buf, v...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+
+	all := make(map[KeyPrefix]Accumulator, len(s.sums))
+	for p, acc := range s.sums {
+		all[p] = acc
+	}
+	for p, acc := range sums {
+		all[p] = acc
+	}
+	record, err := encodePrefixChecksums(newPrefixChecksums(s.opts, all))
+	if err != nil {
+		return err
+	}
+	if len(record) > maxFileFieldLen {
+		return fmt.Errorf("%w: %d byte checksum record", ErrFieldTooLong, len(record))
+	}
+	buf = append(buf, entryCommit)
+	buf = appendUvarint(buf, uint64(len(record)))
+	buf = append(buf, record...)
+	crc := crc32.Update(s.crc, crcTable, buf)
+	buf = binary.LittleEndian.AppendUint32(buf, crc)
+
+	if _, err := s.f.WriteAt(buf, s.size); err != nil {
+		return s.fail(err)
+	}
+	if s.opts.sync {
+		if err := s.f.Sync(); err != nil {
+			return s.fail(err)
+		}
+	}
+	s.size += int64(len(buf))
+	s.crc = crc
+	for k := range del {
+		delete(s.index, k)
+	}
+	for k, v := range vals {
+		s.index[k] = v
+	}
+	for p, acc := range sums {
+		s.sums[p] = acc
+	}
+	return nil
+}
+
+// fail tries to remove partially written batch from the log after write
+// error, the store can't be written to if that's not possible.
+func (s *FileStore) fail(err error) error {
+	if terr := s.f.Truncate(s.size); terr != nil {
+		s.err = fmt.Errorf("log is in unknown state: %w", err)
+	}
+	return err
+}
+
+// Compact rewrites live key-value pairs into a new log segment dropping
+// overwritten and deleted entries. The new segment is synced and its checksum
+// is recalculated from the data written and compared with the one tracked by
+// the store before it replaces the current segment, ErrChecksumMismatch is
+// returned (and the store is left intact) if they differ. It can be called
+// concurrently with other operations (which are blocked while it runs), so
+// it can be used for both on-demand and background compaction.
+func (s *FileStore) Compact() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+	var (
+		seg  = s.seg + 1
+		path = filepath.Join(s.dir, segmentName(seg))
+		tmp  = path + fileTempExt
+	)
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	l, err := s.compactTo(f)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err == nil {
+		err = syncDir(s.dir)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	old, oldSeg := s.f, s.seg
+	s.f, s.seg = f, seg
+	s.index, s.size, s.crc = l.index, l.size, l.crc
+	if s.iters[old] == 0 {
+		_ = old.Close()
+	}
+	return os.Remove(filepath.Join(s.dir, segmentName(oldSeg)))
+}
+
+// compactTo writes live key-value pairs into f as a single batch, syncs it
+// and verifies the result, it's supposed to be called with mutex locked.
+func (s *FileStore) compactTo(f *os.File) (logState, error) {
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var (
+		w   = bufio.NewWriter(f)
+		crc uint32
+		buf = append([]byte(fileMagic), fileVersion)
+	)
+	for _, k := range keys {
+		v, err := s.readValue(s.index[k])
+		if err != nil {
+			return logState{}, err
+		}
+		buf = append(buf, entryPut)
+		buf = appendUvarint(buf, uint64(len(k)))
+		buf = append(buf, k...)
+		buf = appendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+		crc = crc32.Update(crc, crcTable, buf)
+		if _, err := w.Write(buf); err != nil {
+			return logState{}, err
+		}
+		buf = buf[:0]
+	}
+	expected := s.checksumByPrefix()
+	record, err := encodePrefixChecksums(expected)
+	if err != nil {
+		return logState{}, err
+	}
+	buf = append(buf, entryCommit)
+	buf = appendUvarint(buf, uint64(len(record)))
+	buf = append(buf, record...)
+	crc = crc32.Update(crc, crcTable, buf)
+	buf = binary.LittleEndian.AppendUint32(buf, crc)
+	if _, err := w.Write(buf); err != nil {
+		return logState{}, err
+	}
+	if err := w.Flush(); err != nil {
+		return logState{}, err
+	}
+	if err := f.Sync(); err != nil {
+		return logState{}, err
+	}
+
+	// Everything is read back, so the checksum covers what's really stored.
+	l, err := replayLog(f)
+	if err != nil {
+		return l, err
+	}
+	if len(l.index) != len(keys) {
+		return l, fmt.Errorf("%w: compacted segment has %d keys instead of %d",
+			ErrInvalidLog, len(l.index), len(keys))
+	}
+	sums, err := s.calcSums(f, l.index)
+	if err != nil {
+		return l, err
+	}
+	if diff := newPrefixChecksums(s.opts, sums).Diff(expected); len(diff) != 0 {
+		return l, fmt.Errorf("%w: compacted segment differs for prefixes %v",
+			ErrChecksumMismatch, diff)
+	}
+	return l, nil
+}
+
+// syncDir syncs the directory, so that file creation and renames are
+// persisted.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Seek implements the Store interface. Iteration stops if a value can't be
+// read.
+func (s *FileStore) Seek(key []byte, f func(k, v []byte)) {
+	s.SeekRange(KeyRange{Prefix: key}, f)
+}
+
+// SeekRange implements the Store interface. Iteration stops if a value can't
+// be read.
+func (s *FileStore) SeekRange(r KeyRange, f func(k, v []byte)) {
+	iterate(s.NewIterator(r), f)
+}
+
+// NewIterator implements the Store interface. The iterator works with a
+// snapshot of the index reading values from the log when needed, so it's not
+// affected by later changes and Compact (the old segment file is kept open
+// until all of its iterators are released). Closing the store makes active
+// iterators fail.
+func (s *FileStore) NewIterator(r KeyRange) Iterator {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.f == nil {
+		return &fileIterator{err: os.ErrClosed}
+	}
+	var keys []string
+	for k := range s.index {
+		if r.contains(k) {
+			keys = append(keys, k)
+		}
+	}
+	r.sort(keys)
+	vals := make([]fileValue, len(keys))
+	for i, k := range keys {
+		vals[i] = s.index[k]
+	}
+	s.iters[s.f]++
+	return &fileIterator{s: s, f: s.f, keys: keys, vals: vals}
+}
+
+// release is called when the iterator reading from f is released, it closes
+// f if it's an old segment not needed anymore.
+func (s *FileStore) release(f *os.File) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.iters == nil {
+		return
+	}
+	if s.iters[f]--; s.iters[f] == 0 {
+		delete(s.iters, f)
+		if f != s.f {
+			_ = f.Close()
+		}
+	}
+}
+
+// fileIterator is FileStore Iterator.
+type fileIterator struct {
+	s    *FileStore
+	f    *os.File
+	keys []string
+	vals []fileValue
+	key  []byte
+	val  []byte
+	err  error
+}
+
+// Next implements the Iterator interface.
+func (it *fileIterator) Next() bool {
+	it.key, it.val = nil, nil
+	if it.err != nil || len(it.keys) == 0 {
+		return false
+	}
+	val, err := readValue(it.f, it.vals[0])
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.key, it.val = []byte(it.keys[0]), val
+	it.keys, it.vals = it.keys[1:], it.vals[1:]
+	return true
+}
+
+// Key implements the Iterator interface.
+func (it *fileIterator) Key() []byte {
+	return it.key
+}
+
+// Value implements the Iterator interface.
+func (it *fileIterator) Value() []byte {
+	return it.val
+}
+
+// Err implements the Iterator interface.
+func (it *fileIterator) Err() error {
+	return it.err
+}
+
+// Release implements the Iterator interface.
+func (it *fileIterator) Release() {
+	if it.s != nil {
+		it.s.release(it.f)
+		it.s = nil
+	}
+	it.keys, it.vals = nil, nil
+	it.key, it.val = nil, nil
+}
+
+// Checksum implements the Store interface.
+func (s *FileStore) Checksum() Checksum {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.opts.checksum(s.checksumByPrefix().total())
+}
+
+// ChecksumByPrefix implements the Store interface.
+func (s *FileStore) ChecksumByPrefix() PrefixChecksums {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.checksumByPrefix()
+}
+
+// checksumByPrefix is an internal implementation of ChecksumByPrefix, it's
+// supposed to be called with mutex locked.
+func (s *FileStore) checksumByPrefix() PrefixChecksums {
+	return newPrefixChecksums(s.opts, s.sums)
+}
+
+// Close implements the Store interface, it syncs and closes the log.
+func (s *FileStore) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Sync()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	for f := range s.iters {
+		if f != s.f {
+			_ = f.Close()
+		}
+	}
+	s.f = nil
+	s.index = nil
+	s.iters = nil
+	return err
+}
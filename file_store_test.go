@@ -0,0 +1,379 @@
+package xorkv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newFileStoreForTesting(t *testing.T) Store {
+	s, err := OpenFileStore(t.TempDir())
+	require.NoError(t, err)
+	return s
+}
+
+// segmentPath returns the path of the current FileStore segment.
+func segmentPath(s *FileStore) string {
+	return filepath.Join(s.dir, segmentName(s.seg))
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, WithSync())
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	require.NoError(t, s.Put(stKey("empty"), []byte{}))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	b := s.Batch()
+	b.Put(stKey("key"), []byte("newvalue"))
+	b.Delete(stKey("foo"))
+	b.Put(stKey("new"), []byte("new"))
+	require.NoError(t, s.PutBatch(b))
+	require.NoError(t, s.Delete(stKey("absent")))
+	contents, sum := storeContents(s), s.Checksum()
+	require.NoError(t, s.Close())
+
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, contents, storeContents(s))
+	require.Equal(t, sum, s.Checksum())
+	ref := NewMemoryStore()
+	copyStore(t, ref, s)
+	require.Equal(t, ref.Checksum(), s.Checksum())
+	require.Equal(t, ref.ChecksumByPrefix().Map(), s.ChecksumByPrefix().Map())
+	v, err := s.Get(stKey("empty"))
+	require.NoError(t, err)
+	require.Equal(t, []byte{}, v)
+	_, err = s.Get(stKey("foo"))
+	require.Equal(t, ErrKeyNotFound, err)
+	require.NoError(t, s.Close())
+
+	// Different options, checksum is recalculated.
+	opts := []Option{WithAccumulator(new(LtHashAccumulator)), WithScope(FullScope)}
+	s, err = OpenFileStore(dir, opts...)
+	require.NoError(t, err)
+	ref = NewMemoryStore(opts...)
+	copyStore(t, ref, s)
+	require.Equal(t, ref.Checksum(), s.Checksum())
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Close())
+
+	s, err = OpenFileStore(dir, append(opts, WithVerifyRecord())...)
+	require.NoError(t, err)
+	require.NoError(t, ref.Put(stKey("key"), []byte("value")))
+	require.Equal(t, ref.Checksum(), s.Checksum())
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreDamagedTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	contents, sum, size := storeContents(s), s.Checksum(), s.size
+	b := s.Batch()
+	b.Put(stKey("key"), []byte("newvalue"))
+	b.Put(stKey("foo"), []byte("bar"))
+	require.NoError(t, s.PutBatch(b))
+	path, full := segmentPath(s), s.size
+	require.NoError(t, s.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	check := func(t *testing.T, data []byte) {
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+		s, err := OpenFileStore(dir)
+		if err != nil {
+			// Damaged data not running to the end of the log.
+			require.True(t, errors.Is(err, ErrInvalidLog))
+			onDisk, rerr := os.ReadFile(path)
+			require.NoError(t, rerr)
+			require.Equal(t, data, onDisk, "log must not be truncated")
+			return
+		}
+		// The last batch is dropped as a whole.
+		require.Equal(t, contents, storeContents(s))
+		require.Equal(t, sum, s.Checksum())
+		require.Equal(t, size, s.size)
+
+		// The log is truncated and can be appended to.
+		require.NoError(t, s.Put(stKey("other"), []byte{}))
+		require.NoError(t, s.Close())
+		s, err = OpenFileStore(dir)
+		require.NoError(t, err)
+		_, err = s.Get(stKey("other"))
+		require.NoError(t, err)
+		require.NoError(t, s.Close())
+	}
+	for l := size; l < full; l++ {
+		check(t, data[:l])
+		// Interrupted write can leave zeroes instead of data.
+		check(t, append(append([]byte{}, data[:l]...), make([]byte, 100)...))
+	}
+	for i := size; i < full; i++ {
+		damaged := append([]byte{}, data...)
+		damaged[i] ^= 0x01
+		check(t, damaged)
+	}
+	// Damaged commit CRC ends the file.
+	damaged := append([]byte{}, data...)
+	damaged[full-1] ^= 0x01
+	require.NoError(t, os.WriteFile(path, damaged, 0o644))
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, contents, storeContents(s))
+	require.NoError(t, s.Close())
+
+	// Damaged header is an error.
+	damaged = append([]byte{}, data...)
+	damaged[0] = 0
+	require.NoError(t, os.WriteFile(path, damaged, 0o644))
+	_, err = OpenFileStore(dir)
+	require.True(t, errors.Is(err, ErrInvalidLog))
+}
+
+func TestFileStoreDamagedMiddle(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	first := s.size
+	// Big enough for damaged lengths to not run past the end of the log.
+	require.NoError(t, s.Put(stKey("big"), make([]byte, 1<<15)))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	path := segmentPath(s)
+	require.NoError(t, s.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for i := int64(len(fileMagic) + 1); i < first; i++ {
+		damaged := append([]byte{}, data...)
+		damaged[i] ^= 0x01
+		require.NoError(t, os.WriteFile(path, damaged, 0o644))
+		_, err = OpenFileStore(dir)
+		require.True(t, errors.Is(err, ErrInvalidLog), "offset %d", i)
+		onDisk, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, damaged, onDisk, "offset %d", i)
+	}
+}
+
+func TestFileStoreFieldTooLong(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	size := s.size
+
+	err = s.Put(stKey("big"), make([]byte, maxFileFieldLen+1))
+	require.True(t, errors.Is(err, ErrFieldTooLong))
+	b := s.Batch()
+	b.Put(stKey("other"), []byte("value"))
+	b.Put(make([]byte, maxFileFieldLen+1), []byte("value"))
+	require.True(t, errors.Is(s.PutBatch(b), ErrFieldTooLong))
+	// Nothing is written and the store is still usable.
+	require.Equal(t, size, s.size)
+	_, err = s.Get(stKey("other"))
+	require.Equal(t, ErrKeyNotFound, err)
+	require.NoError(t, s.Put(stKey("key2"), []byte("value")))
+	contents, sum := storeContents(s), s.Checksum()
+	require.NoError(t, s.Close())
+
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, contents, storeContents(s))
+	require.Equal(t, sum, s.Checksum())
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreVerifyRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	// Break checksum state.
+	s.sums[STStorage].Add(HashKV("bad", nil))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	require.NoError(t, s.Close())
+
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	_, err = OpenFileStore(dir, WithVerifyRecord())
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func TestFileStoreScope(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, WithScope(FullScope))
+	require.NoError(t, err)
+	require.NoError(t, s.Put(AppendPrefixInt(DataBlock, 1), []byte("block")))
+	require.NoError(t, s.Close())
+
+	// Record made with a custom scope is not used.
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, NewMemoryStore().Checksum(), s.Checksum())
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	// Break checksum state to see whether the record is used.
+	s.sums[STStorage].Add(HashKV("bad", nil))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	broken := s.Checksum()
+	require.NoError(t, s.Close())
+
+	// The same prefixes.
+	s, err = OpenFileStore(dir, WithPrefixScope(stateScopePrefixes...))
+	require.NoError(t, err)
+	require.Equal(t, broken, s.Checksum())
+	require.NoError(t, s.Close())
+
+	// Different prefixes and custom scope.
+	for _, opt := range []Option{WithPrefixScope(STStorage, DataBlock), WithScope(FullScope)} {
+		s, err = OpenFileStore(dir, opt)
+		require.NoError(t, err)
+		ref := NewMemoryStore(opt)
+		copyStore(t, ref, s)
+		require.Equal(t, ref.Checksum(), s.Checksum())
+		require.NoError(t, s.Close())
+	}
+}
+
+func TestFileStoreCached(t *testing.T) {
+	dir := t.TempDir()
+	opts := []Option{WithAccumulator(new(MuHashAccumulator)), WithChecksumRecord()}
+	fs, err := OpenFileStore(dir, opts...)
+	require.NoError(t, err)
+	s := NewMemCachedStore(fs, opts...)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("foo"), []byte("bar")))
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.NoError(t, s.Delete(stKey("foo")))
+	_, err = s.Persist()
+	require.NoError(t, err)
+	sum := s.Checksum()
+	require.Equal(t, sum, fs.Checksum())
+	require.NoError(t, s.Close())
+
+	fs, err = OpenFileStore(dir, opts...)
+	require.NoError(t, err)
+	s, err = OpenMemCachedStore(fs, append(opts, WithVerifyRecord())...)
+	require.NoError(t, err)
+	require.Equal(t, sum, s.Checksum())
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreCompact(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, WithAccumulator(new(ECMHAccumulator)))
+	require.NoError(t, err)
+	require.NoError(t, s.Compact())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Put(stKey("key"), []byte{byte(i)}))
+		require.NoError(t, s.Put(stKey(string(rune('a'+i))), []byte("value")))
+	}
+	require.NoError(t, s.Delete(stKey("a")))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	contents, sum, size := storeContents(s), s.Checksum(), s.size
+	oldPath := segmentPath(s)
+
+	require.NoError(t, s.Compact())
+	require.Less(t, s.size, size)
+	require.Equal(t, contents, storeContents(s))
+	require.Equal(t, sum, s.Checksum())
+	_, err = os.Stat(oldPath)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+
+	// The store is writable after compaction and can be reopened.
+	require.NoError(t, s.Put(stKey("b"), []byte("new")))
+	contents, sum = storeContents(s), s.Checksum()
+	require.NoError(t, s.Close())
+	require.True(t, errors.Is(s.Compact(), os.ErrClosed))
+	s, err = OpenFileStore(dir, WithAccumulator(new(ECMHAccumulator)), WithVerifyRecord())
+	require.NoError(t, err)
+	require.Equal(t, contents, storeContents(s))
+	require.Equal(t, sum, s.Checksum())
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreCompactInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("key"), []byte("newvalue")))
+	contents := storeContents(s)
+	data, err := os.ReadFile(segmentPath(s))
+	require.NoError(t, err)
+	require.NoError(t, s.Compact())
+	require.NoError(t, s.Close())
+
+	// Old segment that wasn't deleted and unfinished compaction result.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, segmentName(1)), data, 0o644))
+	tmp := filepath.Join(dir, segmentName(3)+fileTempExt)
+	require.NoError(t, os.WriteFile(tmp, data[:5], 0o644))
+	s, err = OpenFileStore(dir)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), s.seg)
+	require.Equal(t, contents, storeContents(s))
+	segs, err := listSegments(dir)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{2}, segs)
+	_, err = os.Stat(tmp)
+	require.True(t, errors.Is(err, os.ErrNotExist))
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreCompactMismatch(t *testing.T) {
+	s := newFileStoreForTesting(t).(*FileStore)
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	require.NoError(t, s.Put(stKey("key"), []byte("newvalue")))
+	// Break checksum state.
+	s.sums[STStorage].Add(HashKV("bad", nil))
+	path, size := segmentPath(s), s.size
+
+	require.True(t, errors.Is(s.Compact(), ErrChecksumMismatch))
+	require.Equal(t, path, segmentPath(s))
+	require.Equal(t, size, s.size)
+	entries, err := os.ReadDir(s.dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	v, err := s.Get(stKey("key"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("newvalue"), v)
+	require.NoError(t, s.Close())
+}
+
+func TestFileStoreIteratorCompact(t *testing.T) {
+	s := newFileStoreForTesting(t).(*FileStore)
+	require.NoError(t, s.Put(stKey("a"), []byte("old")))
+	require.NoError(t, s.Put(stKey("b"), []byte("old")))
+	require.NoError(t, s.Put(stKey("a"), []byte("value")))
+	it := s.NewIterator(KeyRange{})
+	require.True(t, it.Next())
+	old := s.f
+
+	// Old segment is kept open for the iterator.
+	require.NoError(t, s.Compact())
+	require.NoError(t, s.Put(stKey("b"), []byte("new")))
+	require.True(t, it.Next())
+	require.Equal(t, stKey("b"), it.Key())
+	require.Equal(t, []byte("old"), it.Value())
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+	it.Release()
+	require.Empty(t, s.iters)
+	_, err := old.Stat()
+	require.True(t, errors.Is(err, os.ErrClosed))
+
+	// Iterators fail after the store is closed.
+	it = s.NewIterator(KeyRange{})
+	require.NoError(t, s.Close())
+	require.False(t, it.Next())
+	require.True(t, errors.Is(it.Err(), os.ErrClosed))
+	it.Release()
+}
@@ -0,0 +1,59 @@
+package xorkv
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"crypto/sha512"
+)
+
+// HashFunc is a hash function used to produce checksum elements from
+// key-value pairs.
+type HashFunc byte
+
+// HashFunc values, they're recorded in checksums, so they can't be changed.
+const (
+	// SHA256 is SHA-256, the default one.
+	SHA256 HashFunc = 0
+	// SHA512t256 is SHA-512/256.
+	SHA512t256 HashFunc = 1
+	// SHA3 is SHA3-256.
+	SHA3 HashFunc = 2
+)
+
+// Sum returns a hash of the data.
+func (h HashFunc) Sum(data []byte) Uint256 {
+	switch h {
+	case SHA256:
+		return sha256.Sum256(data)
+	case SHA512t256:
+		return sha512.Sum512_256(data)
+	case SHA3:
+		return sha3.Sum256(data)
+	default:
+		panic("unknown hash function")
+	}
+}
+
+// String implements the fmt.Stringer interface.
+func (h HashFunc) String() string {
+	switch h {
+	case SHA256:
+		return "SHA-256"
+	case SHA512t256:
+		return "SHA-512/256"
+	case SHA3:
+		return "SHA3-256"
+	default:
+		return "unknown"
+	}
+}
+
+// parseHashFunc returns HashFunc by its String representation.
+func parseHashFunc(s string) (HashFunc, error) {
+	for _, h := range []HashFunc{SHA256, SHA512t256, SHA3} {
+		if h.String() == s {
+			return h, nil
+		}
+	}
+	return 0, ErrInvalidChecksum
+}
@@ -0,0 +1,84 @@
+package xorkv
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashFuncSum(t *testing.T) {
+	var vectors = map[HashFunc]string{
+		SHA256:     "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		SHA512t256: "53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+		SHA3:       "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532",
+	}
+	for h, ref := range vectors {
+		sum := h.Sum([]byte("abc"))
+		require.Equal(t, ref, hex.EncodeToString(sum[:]), h.String())
+	}
+	require.Panics(t, func() { HashFunc(0xff).Sum(nil) })
+	require.Equal(t, "unknown", HashFunc(0xff).String())
+}
+
+func TestStoresHashFunc(t *testing.T) {
+	var sums []Checksum
+	for _, h := range []HashFunc{SHA256, SHA512t256, SHA3} {
+		ps := NewMemoryStore(WithHashFunc(h))
+		s := NewMemCachedStore(ps, WithHashFunc(h))
+		require.NoError(t, s.Put(stKey("key"), []byte("value")))
+		_, err := s.Persist()
+		require.NoError(t, err)
+		require.Equal(t, h, s.Checksum().Hash)
+		require.True(t, ps.Checksum().Equals(s.Checksum()))
+		sums = append(sums, s.Checksum())
+
+		// Deletions in the change checksum use the same function.
+		require.NoError(t, s.Delete(stKey("key")))
+		e := h.Sum(stKey("key"))
+		require.Equal(t, e[:], s.ChangeChecksum().Digest)
+		require.Equal(t, h, s.ChangeChecksum().Hash)
+	}
+	for i := range sums {
+		for j := i + 1; j < len(sums); j++ {
+			require.False(t, sums[i].Equals(sums[j]))
+		}
+	}
+
+	// Even the same digest is not equal if produced by different functions.
+	c := Checksum{Hash: SHA3, Digest: sums[0].Digest}
+	require.False(t, sums[0].Equals(c))
+}
+
+// neoKVSizes are typical key and value sizes of Neo state records.
+var neoKVSizes = []struct {
+	name string
+	key  int
+	val  int
+}{
+	{"STAccount", 21, 100},
+	{"STCoin", 33, 60},
+	{"STValidator", 34, 50},
+	{"STStorage", 40, 64},
+	{"STStorageBig", 40, 1024},
+	{"STContract", 21, 4096},
+}
+
+func BenchmarkHashKV(b *testing.B) {
+	for _, h := range []HashFunc{SHA256, SHA512t256, SHA3} {
+		for _, size := range neoKVSizes {
+			var (
+				o   = newOptions([]Option{WithHashFunc(h)})
+				key = string(make([]byte, size.key))
+				val = make([]byte, size.val)
+			)
+			b.Run(fmt.Sprintf("%s/%s", h, size.name), func(b *testing.B) {
+				b.SetBytes(int64(size.key + size.val))
+				for i := 0; i < b.N; i++ {
+					_ = o.hashKV(key, val)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package xorkv
+
+// Iterator is a pull-style iterator over key-value pairs of a Store returned
+// by NewIterator. It's not safe for concurrent use, but the store can be used
+// (and changed) while the iterator is active, the iterator yields pairs the
+// store had when it was created. Iterators must be released after use.
+type Iterator interface {
+	// Next moves the iterator to the next pair returning false when there
+	// are no more pairs or an error occurred (see Err).
+	Next() bool
+	// Key returns the key of the current pair.
+	Key() []byte
+	// Value returns the value of the current pair.
+	Value() []byte
+	// Err returns the error that stopped iteration if any.
+	Err() error
+	// Release releases iterator resources, Next always returns false after
+	// it. It can be called more than once.
+	Release()
+}
+
+// kvEntry is a key-value pair, deleted entries are used as tombstones for
+// cached pairs.
+type kvEntry struct {
+	key     string
+	val     []byte
+	deleted bool
+}
+
+// iterate calls f for all pairs the iterator yields releasing it afterwards.
+func iterate(it Iterator, f func(k, v []byte)) {
+	defer it.Release()
+	for it.Next() {
+		f(it.Key(), it.Value())
+	}
+}
+
+// sliceIterator iterates over a sorted snapshot of entries skipping deleted
+// ones.
+type sliceIterator struct {
+	entries []kvEntry
+	key     []byte
+	val     []byte
+}
+
+// Next implements the Iterator interface.
+func (it *sliceIterator) Next() bool {
+	for len(it.entries) != 0 {
+		e := it.entries[0]
+		it.entries = it.entries[1:]
+		if !e.deleted {
+			it.key, it.val = []byte(e.key), e.val
+			return true
+		}
+	}
+	it.key, it.val = nil, nil
+	return false
+}
+
+// Key implements the Iterator interface.
+func (it *sliceIterator) Key() []byte {
+	return it.key
+}
+
+// Value implements the Iterator interface.
+func (it *sliceIterator) Value() []byte {
+	return it.val
+}
+
+// Err implements the Iterator interface, it's always nil.
+func (it *sliceIterator) Err() error {
+	return nil
+}
+
+// Release implements the Iterator interface.
+func (it *sliceIterator) Release() {
+	it.entries = nil
+	it.key, it.val = nil, nil
+}
+
+// mergeIterator merges cached entries snapshot with the lower Store iterator,
+// cached entries (including deleted ones) take precedence.
+type mergeIterator struct {
+	r     KeyRange
+	cache *btreeIter
+	lower Iterator
+	// centry is the current cached entry, cok is set if there is one.
+	centry kvEntry
+	cok    bool
+	// lkey and lval are the current lower pair, lok is set if there is one.
+	lkey    []byte
+	lval    []byte
+	lok     bool
+	started bool
+	key     []byte
+	val     []byte
+}
+
+// nextCache advances the cache iterator.
+func (it *mergeIterator) nextCache() {
+	if it.cache == nil {
+		it.centry, it.cok = kvEntry{}, false
+		return
+	}
+	it.centry, it.cok = it.cache.next()
+}
+
+// nextLower advances the lower iterator, iteration stops on its error.
+func (it *mergeIterator) nextLower() {
+	it.lok = it.lower.Next()
+	if it.lok {
+		it.lkey, it.lval = it.lower.Key(), it.lower.Value()
+		return
+	}
+	it.lkey, it.lval = nil, nil
+	if it.lower.Err() != nil {
+		it.cache = nil
+		it.nextCache()
+	}
+}
+
+// Next implements the Iterator interface.
+func (it *mergeIterator) Next() bool {
+	if !it.started {
+		it.started = true
+		it.nextCache()
+		it.nextLower()
+	}
+	for {
+		if it.cok && (!it.lok || !it.r.less(string(it.lkey), it.centry.key)) {
+			e := it.centry
+			it.nextCache()
+			if it.lok && string(it.lkey) == e.key {
+				it.nextLower()
+			}
+			if e.deleted {
+				continue
+			}
+			it.key, it.val = []byte(e.key), e.val
+			return true
+		}
+		if !it.lok {
+			it.key, it.val = nil, nil
+			return false
+		}
+		it.key, it.val = it.lkey, it.lval
+		it.nextLower()
+		return true
+	}
+}
+
+// Key implements the Iterator interface.
+func (it *mergeIterator) Key() []byte {
+	return it.key
+}
+
+// Value implements the Iterator interface.
+func (it *mergeIterator) Value() []byte {
+	return it.val
+}
+
+// Err implements the Iterator interface returning the lower Store iterator
+// error.
+func (it *mergeIterator) Err() error {
+	return it.lower.Err()
+}
+
+// Release implements the Iterator interface.
+func (it *mergeIterator) Release() {
+	it.cache = nil
+	it.centry, it.cok = kvEntry{}, false
+	it.lok, it.started = false, true
+	it.key, it.val = nil, nil
+	it.lower.Release()
+}
@@ -0,0 +1,109 @@
+package xorkv
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// iteratorPairs returns all pairs yielded by the iterator releasing it.
+func iteratorPairs(t *testing.T, it Iterator) [][2]string {
+	defer it.Release()
+	var res [][2]string
+	for it.Next() {
+		res = append(res, [2]string{string(it.Key()), string(it.Value())})
+	}
+	require.NoError(t, it.Err())
+	return res
+}
+
+func testStoreIterator(t *testing.T, s Store) {
+	for _, k := range []string{"c", "a", "b", "d"} {
+		require.NoError(t, s.Put([]byte(k), []byte("v"+k)))
+	}
+	it := s.NewIterator(KeyRange{Start: []byte("b")})
+	require.True(t, it.Next())
+	require.Equal(t, []byte("b"), it.Key())
+	require.Equal(t, []byte("vb"), it.Value())
+
+	// Changes made during iteration are not visible.
+	require.NoError(t, s.Delete([]byte("c")))
+	require.NoError(t, s.Put([]byte("bb"), []byte("vbb")))
+	require.NoError(t, s.Put([]byte("d"), []byte("new")))
+	require.True(t, it.Next())
+	require.Equal(t, []byte("c"), it.Key())
+	require.Equal(t, []byte("vc"), it.Value())
+
+	// Early termination.
+	it.Release()
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+	it.Release()
+
+	require.Equal(t, [][2]string{{"d", "new"}, {"bb", "vbb"}, {"b", "vb"}},
+		iteratorPairs(t, s.NewIterator(KeyRange{Start: []byte("b"), Reverse: true})))
+
+	// Seek callback can use the store.
+	s.Seek(nil, func(k, v []byte) {
+		require.NoError(t, s.Put(append(k, 'x'), v))
+	})
+	require.Equal(t, [][2]string{{"a", "va"}, {"ax", "va"}, {"b", "vb"}, {"bb", "vbb"},
+		{"bbx", "vbb"}, {"bx", "vb"}, {"d", "new"}, {"dx", "new"}},
+		iteratorPairs(t, s.NewIterator(KeyRange{})))
+	require.NoError(t, s.Close())
+}
+
+func TestCachedIterator(t *testing.T) {
+	ps := NewMemoryStore()
+	for _, k := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, ps.Put([]byte(k), []byte("lower")))
+	}
+	s := NewMemCachedStore(ps)
+	require.NoError(t, s.Delete([]byte("a")))
+	require.NoError(t, s.Put([]byte("b"), []byte("cached")))
+	require.NoError(t, s.Delete([]byte("bb")))
+	require.NoError(t, s.Put([]byte("e"), []byte("cached")))
+
+	it := s.NewIterator(KeyRange{})
+	require.True(t, it.Next())
+	require.Equal(t, []byte("b"), it.Key())
+	// Persisting the cache doesn't affect active iterators.
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.NoError(t, s.Delete([]byte("c")))
+	require.Equal(t, [][2]string{{"c", "lower"}, {"d", "lower"}, {"e", "cached"}},
+		iteratorPairs(t, it))
+	require.Equal(t, [][2]string{{"e", "cached"}, {"d", "lower"}, {"b", "cached"}},
+		iteratorPairs(t, s.NewIterator(KeyRange{Reverse: true})))
+}
+
+func TestCachedIteratorError(t *testing.T) {
+	fs := newFileStoreForTesting(t).(*FileStore)
+	require.NoError(t, fs.Put([]byte("a"), []byte("lower")))
+	require.NoError(t, fs.Put([]byte("c"), []byte("lower")))
+	require.NoError(t, fs.Put([]byte("e"), []byte("lower")))
+	s := NewMemCachedStore(fs)
+	require.NoError(t, s.Put([]byte("b"), []byte("cached")))
+	require.NoError(t, s.Put([]byte("d"), []byte("cached")))
+
+	it := s.NewIterator(KeyRange{})
+	require.True(t, it.Next())
+	require.Equal(t, []byte("a"), it.Key())
+	require.NoError(t, fs.Close())
+	// "c" is already read, but the next lower value can't be, cached pairs
+	// after it are not returned too.
+	require.True(t, it.Next())
+	require.Equal(t, []byte("b"), it.Key())
+	require.True(t, it.Next())
+	require.Equal(t, []byte("c"), it.Key())
+	require.False(t, it.Next())
+	require.True(t, errors.Is(it.Err(), os.ErrClosed))
+	it.Release()
+
+	it = s.NewIterator(KeyRange{})
+	require.False(t, it.Next())
+	require.True(t, errors.Is(it.Err(), os.ErrClosed))
+	it.Release()
+}
@@ -0,0 +1,96 @@
+package xorkv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const (
+	// ltHashLanes is the number of 16-bit lanes in LtHashAccumulator.
+	ltHashLanes = 1024
+	// ltHashStateSize is the size of serialized LtHashAccumulator state.
+	ltHashStateSize = ltHashLanes * 2
+)
+
+// LtHashAccumulator is a lattice-based homomorphic hash (LtHash16)
+// Accumulator. Every element is expanded into a vector of 1024 16-bit lanes
+// that are added to (or subtracted from) the state modulo 2^16. Unlike XOR
+// it's not vulnerable to generalized birthday attacks.
+type LtHashAccumulator [ltHashLanes]uint16
+
+// ltHashExpand expands the element into LtHash lanes using SHA-256 in counter
+// mode.
+func ltHashExpand(e Uint256) *LtHashAccumulator {
+	var (
+		res LtHashAccumulator
+		buf = make([]byte, len(e)+4)
+	)
+	copy(buf, e[:])
+	for i := 0; i < ltHashStateSize/sha256.Size; i++ {
+		binary.LittleEndian.PutUint32(buf[len(e):], uint32(i))
+		block := sha256.Sum256(buf)
+		for j := 0; j < sha256.Size; j += 2 {
+			res[i*sha256.Size/2+j/2] = binary.LittleEndian.Uint16(block[j:])
+		}
+	}
+	return &res
+}
+
+// Add implements the Accumulator interface.
+func (a *LtHashAccumulator) Add(e Uint256) {
+	a.Combine(ltHashExpand(e))
+}
+
+// Remove implements the Accumulator interface.
+func (a *LtHashAccumulator) Remove(e Uint256) {
+	v := ltHashExpand(e)
+	for i := range a {
+		a[i] -= v[i]
+	}
+}
+
+// Combine implements the Accumulator interface.
+func (a *LtHashAccumulator) Combine(o Accumulator) {
+	v := o.(*LtHashAccumulator)
+	for i := range a {
+		a[i] += v[i]
+	}
+}
+
+// Sum implements the Accumulator interface, the digest is a SHA-256 hash of
+// the accumulator state.
+func (a *LtHashAccumulator) Sum() []byte {
+	data, _ := a.MarshalBinary()
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// Zero implements the Accumulator interface.
+func (a *LtHashAccumulator) Zero() Accumulator {
+	return new(LtHashAccumulator)
+}
+
+// Kind implements the Accumulator interface.
+func (a *LtHashAccumulator) Kind() AccumulatorKind {
+	return KindLtHash
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface.
+func (a *LtHashAccumulator) MarshalBinary() ([]byte, error) {
+	res := make([]byte, ltHashStateSize)
+	for i := range a {
+		binary.LittleEndian.PutUint16(res[i*2:], a[i])
+	}
+	return res, nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (a *LtHashAccumulator) UnmarshalBinary(data []byte) error {
+	if len(data) != ltHashStateSize {
+		return ErrInvalidAccumulatorState
+	}
+	for i := range a {
+		a[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return nil
+}
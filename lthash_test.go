@@ -0,0 +1,21 @@
+package xorkv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLtHashExpand(t *testing.T) {
+	e := HashKV("key", []byte("value"))
+	v := ltHashExpand(e)
+	require.Equal(t, v, ltHashExpand(e))
+	require.NotEqual(t, v, ltHashExpand(HashKV("key", []byte("other"))))
+
+	// Empty state digest is the same for any empty accumulator.
+	require.Equal(t, new(LtHashAccumulator).Sum(), new(LtHashAccumulator).Sum())
+}
+
+func TestLtHashStores(t *testing.T) {
+	testStoresAccumulatorConsistency(t, new(LtHashAccumulator))
+}
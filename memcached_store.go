@@ -1,146 +1,480 @@
 package xorkv
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
 )
 
 // MemCachedStore is a wrapper around persistent store that caches all changes
 // being made for them to be later flushed in one batch.
 type MemCachedStore struct {
-	MemoryStore
+	mut  sync.RWMutex
+	opts options
 
 	// Persistent Store.
 	ps Store
+	// Values keys had in ps before they were first changed in the cache, nil
+	// values for keys that were not present there.
+	orig map[string][]byte
+	// cache contains cached changes ordered by key, deleted keys are
+	// tombstones.
+	cache btree
 
-	stateSum Uint256
+	// prefixSums are per-KeyPrefix state checksums, the total one is derived
+	// from them when needed.
+	prefixSums map[KeyPrefix]Accumulator
+
+	// undo is the log of changes made after the first active savepoint.
+	undo []undoEntry
+	// savepoints is the stack of active savepoints.
+	savepoints []Savepoint
+	// savepointID is the id of the last created savepoint.
+	savepointID uint64
+
+	// undoSeq is the sequence number of the last undo record in ps, it's
+	// only valid if undoSeqKnown is set.
+	undoSeq      uint64
+	undoSeqKnown bool
+}
+
+// NewMemCachedStore creates a new MemCachedStore object with state checksum
+// initialized from the lower Store checksum. If the lower Store uses different
+// checksum algorithm or Scope (or a custom one, see WithScope) the checksum is
+// recalculated from all of its data instead. Note that it panics if the lower
+// Store can't be read then (it couldn't fail before options were introduced),
+// OpenMemCachedStore returns an error instead.
+func NewMemCachedStore(lower Store, opts ...Option) *MemCachedStore {
+	s := newMemCachedStore(lower, opts)
+	if err := s.seed(lower, lower.ChecksumByPrefix()); err != nil {
+		panic(err)
+	}
+	return s
 }
 
-// NewMemCachedStore creates a new MemCachedStore object.
-func NewMemCachedStore(lower Store) *MemCachedStore {
+// OpenMemCachedStore creates a new MemCachedStore object for the lower Store
+// that may have a checksum record persisted by the other MemCachedStore (see
+// WithChecksumRecord). The record is used to initialize state checksum if
+// it's present and enabled, WithVerifyRecord additionally checks it against
+// the checksum recalculated from all lower Store data (not the one tracked by
+// the lower Store). The lower Store checksum is used if there is no record.
+// Like in NewMemCachedStore, the checksum is recalculated if the record or
+// the lower Store checksum was calculated with different options.
+func OpenMemCachedStore(lower Store, opts ...Option) (*MemCachedStore, error) {
+	s := newMemCachedStore(lower, opts)
+	var (
+		record []byte
+		err    error
+	)
+	if s.opts.record {
+		record, err = lower.Get(checksumRecordKey)
+		if err != nil && err != ErrKeyNotFound {
+			return nil, err
+		}
+	}
+	var sums PrefixChecksums
+	if record == nil {
+		sums = lower.ChecksumByPrefix()
+	} else if sums, err = s.loadRecord(lower, record); err != nil {
+		return nil, err
+	}
+	if err := s.seed(lower, sums); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadRecord decodes the checksum record verifying it if needed, empty sums
+// are returned for records made with different algorithm, so that the
+// checksum is recalculated.
+func (s *MemCachedStore) loadRecord(lower Store, record []byte) (PrefixChecksums, error) {
+	sums, err := decodePrefixChecksums(record, s.opts)
+	if errors.Is(err, ErrChecksumMismatch) {
+		return PrefixChecksums{}, nil
+	}
+	if err != nil || !s.opts.verify || !s.opts.sameScope(sums.opts) {
+		return sums, err
+	}
+	full, err := calcPrefixChecksums(lower, s.opts)
+	if err != nil {
+		return sums, err
+	}
+	if diff := sums.Diff(full); len(diff) != 0 {
+		return sums, fmt.Errorf("%w: record differs from the lower store for prefixes %v",
+			ErrChecksumMismatch, diff)
+	}
+	return sums, nil
+}
+
+// newMemCachedStore creates a new MemCachedStore object with uninitialized
+// state checksum.
+func newMemCachedStore(lower Store, opts []Option) *MemCachedStore {
 	return &MemCachedStore{
-		MemoryStore: *NewMemoryStore(),
-		ps:          lower,
+		opts: newOptions(opts),
+		ps:   lower,
+		orig: make(map[string][]byte),
 	}
 }
 
-// Delete implements the Store interface.
-func (s *MemCachedStore) Delete(key []byte) error {
-	strKey := string(key)
-	// Double Delete is a noop.
-	if s.del[strKey] {
+// seed initializes state checksum with the given one if it's calculated with
+// the same algorithm and scope, otherwise it's recalculated from the lower
+// Store data.
+func (s *MemCachedStore) seed(lower Store, sums PrefixChecksums) error {
+	if sums.accs == nil || !s.opts.sameAlgorithm(sums.opts) || !s.opts.sameScope(sums.opts) {
+		var err error
+		if sums, err = calcPrefixChecksums(lower, s.opts); err != nil {
+			return err
+		}
+	}
+	s.prefixSums = make(map[KeyPrefix]Accumulator, len(sums.accs))
+	for p, acc := range sums.accs {
+		s.prefixSums[p] = cloneAccumulator(acc)
+	}
+	return nil
+}
+
+// stateSum returns the state checksum accumulator combining per-prefix ones,
+// it's supposed to be called with mutex locked.
+func (s *MemCachedStore) stateSum() Accumulator {
+	return PrefixChecksums{opts: s.opts, accs: s.prefixSums}.total()
+}
+
+// addSum adds key-value pair to the state checksum.
+func (s *MemCachedStore) addSum(k string, v []byte) {
+	s.prefixSum(k).Add(s.opts.hashKV(k, v))
+}
+
+// removeSum removes key-value pair from the state checksum.
+func (s *MemCachedStore) removeSum(k string, v []byte) {
+	s.prefixSum(k).Remove(s.opts.hashKV(k, v))
+}
+
+// prefixSum returns the accumulator for the key's prefix.
+func (s *MemCachedStore) prefixSum(k string) Accumulator {
+	p := keyPrefix(k)
+	acc, ok := s.prefixSums[p]
+	if !ok {
+		acc = s.opts.acc.Zero()
+		s.prefixSums[p] = acc
+	}
+	return acc
+}
+
+// origValueOf returns the value the key had in the lower store before it was
+// first changed in the cache (nil if there was no value), it remembers this
+// value on the first successful call for every key.
+func (s *MemCachedStore) origValueOf(k string) ([]byte, error) {
+	if val, ok := s.orig[k]; ok {
+		return val, nil
+	}
+	val, err := s.ps.Get([]byte(k))
+	switch {
+	case err == ErrKeyNotFound:
+		val = nil
+	case err != nil:
+		return nil, err
+	case val == nil:
+		val = []byte{}
+	}
+	s.orig[k] = val
+	return val, nil
+}
+
+// prepare fetches the original value of the key if it's needed to update
+// state checksum when the key is changed, it's supposed to be called with
+// mutex locked before put and drop. Original values of keys in scope are
+// always known after that, so currentValue can be used for them.
+func (s *MemCachedStore) prepare(key string) error {
+	if !s.opts.inScope([]byte(key)) {
 		return nil
 	}
-	if val, ok := s.mem[strKey]; ok {
-		// The value was added, but now we're deleting it.
-		s.stateSum.Xor(HashKV(strKey, val))
-	} else if val, err := s.ps.Get(key); err == nil {
-		// The value is present in the lower store, but now we're deleting it.
-		s.stateSum.Xor(HashKV(strKey, val))
+	_, err := s.origValueOf(key)
+	return err
+}
+
+// currentValue returns the value the key has with all cached changes applied
+// (nil if there is no value), the original value of the key must be known
+// (see prepare).
+func (s *MemCachedStore) currentValue(k string) []byte {
+	if e, ok := s.cache.get(k); ok {
+		if e.deleted {
+			return nil
+		}
+		return e.val
 	}
-	return s.MemoryStore.Delete(key)
+	return s.orig[k]
 }
 
-// Put implements the Store interface.
+// drop deletes the key from the cache updating state checksum, it's supposed
+// to be called with mutex locked after prepare.
+func (s *MemCachedStore) drop(key string) {
+	// Double Delete is a noop.
+	if e, ok := s.cache.get(key); ok && e.deleted {
+		return
+	}
+	s.logChange(key)
+	if s.opts.inScope([]byte(key)) {
+		if val := s.currentValue(key); val != nil {
+			s.removeSum(key, val)
+		}
+	}
+	s.cache.set(kvEntry{key: key, deleted: true})
+}
+
+// Delete implements the Store interface. It only fails if the previous value
+// of the key can't be read from the lower store.
+func (s *MemCachedStore) Delete(key []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := s.prepare(string(key)); err != nil {
+		return err
+	}
+	s.drop(string(key))
+	return nil
+}
+
+// put puts a key-value pair into the cache updating state checksum, it's
+// supposed to be called with mutex locked after prepare.
+func (s *MemCachedStore) put(key string, value []byte) {
+	s.logChange(key)
+	if s.opts.inScope([]byte(key)) {
+		if oldVal := s.currentValue(key); oldVal != nil {
+			s.removeSum(key, oldVal)
+		}
+		s.addSum(key, value)
+	}
+	s.cache.set(kvEntry{key: key, val: value})
+}
+
+// Put implements the Store interface. It only fails if the previous value of
+// the key can't be read from the lower store.
 func (s *MemCachedStore) Put(key, value []byte) error {
-	strKey := string(key)
-	if oldVal, ok := s.mem[strKey]; ok {
-		// We've already updated the value and now are doing it again.
-		s.stateSum.Xor(HashKV(strKey, oldVal))
-	} else if oldVal, err := s.ps.Get(key); err == nil {
-		// The first update to already existing value.
-		s.stateSum.Xor(HashKV(strKey, oldVal))
+	vcopy := make([]byte, len(value))
+	copy(vcopy, value)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := s.prepare(string(key)); err != nil {
+		return err
 	}
-	s.stateSum.Xor(HashKV(strKey, value))
-	return s.MemoryStore.Put(key, value)
+	s.put(string(key), vcopy)
+	return nil
+}
+
+// PutBatch implements the Store interface, it atomically applies all batch
+// changes to the cache updating state checksum the same way Put and Delete
+// do. It only fails (without changing anything) if previous values of keys
+// can't be read from the lower store.
+func (s *MemCachedStore) PutBatch(batch Batch) error {
+	b := batch.(*MemoryBatch)
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for k := range b.del {
+		if err := s.prepare(k); err != nil {
+			return err
+		}
+	}
+	for k := range b.mem {
+		if err := s.prepare(k); err != nil {
+			return err
+		}
+	}
+	// Batch only keeps the final state of each key, so it's either deleted or
+	// put.
+	for k := range b.del {
+		s.drop(k)
+	}
+	for k, v := range b.mem {
+		s.put(k, v)
+	}
+	return nil
 }
 
 // Get implements the Store interface.
 func (s *MemCachedStore) Get(key []byte) ([]byte, error) {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
-	k := string(key)
-	if val, ok := s.mem[k]; ok {
-		return val, nil
-	}
-	if _, ok := s.del[k]; ok {
-		return nil, ErrKeyNotFound
+	if e, ok := s.cache.get(string(key)); ok {
+		if e.deleted {
+			return nil, ErrKeyNotFound
+		}
+		return e.val, nil
 	}
 	return s.ps.Get(key)
 }
 
 // Seek implements the Store interface.
 func (s *MemCachedStore) Seek(key []byte, f func(k, v []byte)) {
+	s.SeekRange(KeyRange{Prefix: key}, f)
+}
+
+// SeekRange implements the Store interface, cached and lower Store pairs are
+// merged, so keys are iterated in order.
+func (s *MemCachedStore) SeekRange(r KeyRange, f func(k, v []byte)) {
+	iterate(s.NewIterator(r), f)
+}
+
+// NewIterator implements the Store interface, it merges a snapshot of cached
+// changes with the lower Store iterator. The lock is only held while the
+// iterator is created.
+func (s *MemCachedStore) NewIterator(r KeyRange) Iterator {
 	s.mut.RLock()
 	defer s.mut.RUnlock()
-	s.MemoryStore.Seek(key, f)
-	s.ps.Seek(key, func(k, v []byte) {
-		elem := string(k)
-		// If it's in mem, we already called f() for it in MemoryStore.Seek().
-		_, present := s.mem[elem]
-		if !present {
-			// If it's in del, we shouldn't be calling f() anyway.
-			_, present = s.del[elem]
-		}
-		if !present {
-			f(k, v)
-		}
-	})
+	return &mergeIterator{
+		r:     r,
+		cache: newBTreeIter(s.cache.snapshot(), r),
+		lower: s.ps.NewIterator(r),
+	}
+}
+
+// Batch implements the Store interface and returns a compatible Batch.
+func (s *MemCachedStore) Batch() Batch {
+	return newMemoryBatch()
 }
 
-// Persist flushes all the MemoryStore contents into the (supposedly) persistent
+// Persist flushes all the cached changes into the (supposedly) persistent
 // store ps.
 func (s *MemCachedStore) Persist() (int, error) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	batch := s.ps.Batch()
 	keys, dkeys := 0, 0
-	for k, v := range s.mem {
-		batch.Put([]byte(k), v)
-		keys++
-	}
-	for k := range s.del {
-		batch.Delete([]byte(k))
-		dkeys++
-	}
-	var err error
+	s.cache.ascend(func(e kvEntry) {
+		if e.deleted {
+			batch.Delete([]byte(e.key))
+			dkeys++
+		} else {
+			batch.Put([]byte(e.key), e.val)
+			keys++
+		}
+	})
+	var (
+		err     error
+		undoSeq uint64
+	)
 	if keys != 0 || dkeys != 0 {
+		if s.opts.undoRecords {
+			undo, err := s.undoRecord()
+			if err != nil {
+				return 0, err
+			}
+			undoSeq = s.lastUndoRecord() + 1
+			batch.Put(undoRecordKey(undoSeq), undo)
+		}
+		if s.opts.history {
+			if err := s.addHistory(batch); err != nil {
+				return 0, err
+			}
+		}
+		if s.opts.record {
+			record, err := encodePrefixChecksums(s.checksumByPrefix())
+			if err != nil {
+				return 0, err
+			}
+			batch.Put(checksumRecordKey, record)
+		}
 		err = s.ps.PutBatch(batch)
 	}
 	if err == nil {
-		s.mem = make(map[string][]byte)
-		s.del = make(map[string]bool)
+		if undoSeq != 0 {
+			s.undoSeq = undoSeq
+		}
+		s.reset()
 	}
 	return keys, err
 }
 
+// Discard drops all cached changes without persisting them, the checksum is
+// restored to the one matching the lower store.
+func (s *MemCachedStore) Discard() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for k, val := range s.orig {
+		// Changeset can remember values of keys that are not in scope.
+		if !s.opts.inScope([]byte(k)) {
+			continue
+		}
+		cur := s.currentValue(k)
+		if bytes.Equal(cur, val) && (cur == nil) == (val == nil) {
+			continue
+		}
+		if cur != nil {
+			s.removeSum(k, cur)
+		}
+		if val != nil {
+			s.addSum(k, val)
+		}
+	}
+	s.reset()
+}
+
+// reset clears all cached changes, it's supposed to be called with mutex
+// locked.
+func (s *MemCachedStore) reset() {
+	s.orig = make(map[string][]byte)
+	s.cache.reset()
+	s.undo = nil
+	s.savepoints = nil
+}
+
 // Checksum returns current storage contents checksum incrementally calculated
 // by the storage change operations.
-func (s *MemCachedStore) Checksum() Uint256 {
-	return s.stateSum
+func (s *MemCachedStore) Checksum() Checksum {
+	// Exclusive lock, because accumulators can normalize their state in Sum.
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.opts.checksum(s.stateSum())
+}
+
+// ChecksumByPrefix returns incrementally calculated state checksums grouped by
+// KeyPrefix.
+func (s *MemCachedStore) ChecksumByPrefix() PrefixChecksums {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.checksumByPrefix()
+}
+
+// checksumByPrefix is an internal implementation of ChecksumByPrefix, it's
+// supposed to be called with mutex locked.
+func (s *MemCachedStore) checksumByPrefix() PrefixChecksums {
+	return newPrefixChecksums(s.opts, s.prefixSums)
 }
 
 // ChangeChecksum returns checksum for the current storage changeset relative
-// to the persistent store.
-func (s *MemCachedStore) ChangeChecksum() Uint256 {
-	var calcChangeSum = Uint256{}
+// to the persistent store. Deletions are hashed as keys alone using the same
+// hash function as key-value pairs.
+func (s *MemCachedStore) ChangeChecksum() Checksum {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.changeChecksum()
+}
 
-	for k, v := range s.mem {
-		calcChangeSum.Xor(HashKV(k, v))
-	}
-	for k := range s.del {
-		// Don't checksum if key is absent in the lower store, as it's
-		// a no-op effectively.
-		if _, err := s.ps.Get([]byte(k)); err == nil {
-			calcChangeSum.Xor(sha256.Sum256([]byte(k)))
+// changeChecksum is an internal implementation of ChangeChecksum, it's
+// supposed to be called with mutex locked.
+func (s *MemCachedStore) changeChecksum() Checksum {
+	var calcChangeSum = s.opts.acc.Zero()
+
+	s.cache.ascend(func(e kvEntry) {
+		switch {
+		case !s.opts.inScope([]byte(e.key)):
+		case !e.deleted:
+			calcChangeSum.Add(s.opts.hashKV(e.key, e.val))
+		default:
+			// Don't checksum if key is absent in the lower store, as it's
+			// a no-op effectively.
+			if s.orig[e.key] != nil {
+				calcChangeSum.Add(s.opts.hash.Sum([]byte(e.key)))
+			}
 		}
-	}
-	return calcChangeSum
+	})
+	return s.opts.checksum(calcChangeSum)
 }
 
 // Close implements Store interface, clears up memory and closes the lower layer
 // Store.
 func (s *MemCachedStore) Close() error {
-	// It's always successful.
-	_ = s.MemoryStore.Close()
+	s.mut.Lock()
+	s.cache.reset()
+	s.mut.Unlock()
 	return s.ps.Close()
 }
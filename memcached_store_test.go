@@ -1,6 +1,11 @@
 package xorkv
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,9 +29,8 @@ func TestMemCachedStorePersist(t *testing.T) {
 	v, err := ps.Get([]byte("key"))
 	assert.Equal(t, nil, err)
 	assert.Equal(t, []byte("value"), v)
-	v, err = ts.MemoryStore.Get([]byte("key"))
-	assert.Equal(t, ErrKeyNotFound, err)
-	assert.Equal(t, []byte(nil), v)
+	_, ok := ts.cache.get("key")
+	assert.False(t, ok)
 	// now we overwrite the previous `key` contents and also add `key2`,
 	assert.NoError(t, ts.Put([]byte("key"), []byte("newvalue")))
 	assert.NoError(t, ts.Put([]byte("key2"), []byte("value2")))
@@ -40,12 +44,7 @@ func TestMemCachedStorePersist(t *testing.T) {
 	c, err = ts.Persist()
 	assert.Equal(t, nil, err)
 	assert.Equal(t, 2, c)
-	v, err = ts.MemoryStore.Get([]byte("key"))
-	assert.Equal(t, ErrKeyNotFound, err)
-	assert.Equal(t, []byte(nil), v)
-	v, err = ts.MemoryStore.Get([]byte("key2"))
-	assert.Equal(t, ErrKeyNotFound, err)
-	assert.Equal(t, []byte(nil), v)
+	assert.Equal(t, 0, ts.cache.len)
 	v, err = ps.Get([]byte("key"))
 	assert.Equal(t, nil, err)
 	assert.Equal(t, []byte("newvalue"), v)
@@ -136,14 +135,58 @@ func TestCachedSeek(t *testing.T) {
 	}
 }
 
+func TestCachedSeekRange(t *testing.T) {
+	var (
+		r    = rand.New(rand.NewSource(42))
+		ps   = NewMemoryStore()
+		s    = NewMemCachedStore(ps)
+		ref  = NewMemoryStore()
+		keys = make([][]byte, 40)
+	)
+	for i := range keys {
+		keys[i] = stKey(fmt.Sprintf("%02d", i))
+	}
+	for i := 0; i < 100; i++ {
+		k := keys[r.Intn(len(keys))]
+		switch op := r.Intn(10); {
+		case op < 5:
+			v := []byte{byte(i)}
+			require.NoError(t, s.Put(k, v))
+			require.NoError(t, ref.Put(k, v))
+		case op < 8:
+			require.NoError(t, s.Delete(k))
+			require.NoError(t, ref.Delete(k))
+		case op < 9:
+			_, err := s.Persist()
+			require.NoError(t, err)
+		}
+
+		rng := KeyRange{Prefix: STStorage.Bytes(), Reverse: r.Intn(2) == 0}
+		if r.Intn(2) == 0 {
+			rng.Start = keys[r.Intn(len(keys))]
+		}
+		if r.Intn(2) == 0 {
+			rng.End = keys[r.Intn(len(keys))]
+		}
+		var expected, actual [][2]string
+		ref.SeekRange(rng, func(k, v []byte) {
+			expected = append(expected, [2]string{string(k), string(v)})
+		})
+		s.SeekRange(rng, func(k, v []byte) {
+			actual = append(actual, [2]string{string(k), string(v)})
+		})
+		require.Equal(t, expected, actual, "op %d", i)
+	}
+}
+
 func TestCachedStateSimple(t *testing.T) {
 	ps := NewMemoryStore()
 	s := NewMemCachedStore(ps)
-	h0 := Uint256{}
-	kv1 := [][]byte{[]byte("key"), []byte("value")}
-	kv2 := [][]byte{[]byte("foo"), []byte("bar")}
-	kv3 := [][]byte{[]byte("bar"), []byte("baz")}
-	kv3s := [][]byte{[]byte("bar"), []byte("zab")}
+	h0 := defaultChecksum(make([]byte, len(Uint256{})))
+	kv1 := [][]byte{stKey("key"), []byte("value")}
+	kv2 := [][]byte{stKey("foo"), []byte("bar")}
+	kv3 := [][]byte{stKey("bar"), []byte("baz")}
+	kv3s := [][]byte{stKey("bar"), []byte("zab")}
 
 	// Put three KV pairs into the store
 	require.NoError(t, s.Put(kv1[0], kv1[1]))
@@ -184,7 +227,8 @@ func TestCachedStateSimple(t *testing.T) {
 	// an updated KV pair.
 	require.NoError(t, s.Put(kv3[0], kv3[1]))
 	require.NoError(t, s.Put(kv3s[0], kv3s[1]))
-	require.Equal(t, HashKV(string(kv3s[0]), kv3s[1]), s.ChangeChecksum())
+	hkv3s := HashKV(string(kv3s[0]), kv3s[1])
+	require.Equal(t, defaultChecksum(hkv3s[:]), s.ChangeChecksum())
 
 	// Put old kv3 value and persist it, we should end up with the same sum as
 	// in the first part of the test.
@@ -204,3 +248,399 @@ func TestCachedStateSimple(t *testing.T) {
 func newMemCachedStoreForTesting(t *testing.T) Store {
 	return NewMemCachedStore(NewMemoryStore())
 }
+
+func TestCachedSeedFromLower(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("key"), []byte("value")))
+	require.NoError(t, ps.Put(AppendPrefix(STAccount, []byte("acc")), []byte("value")))
+
+	s := NewMemCachedStore(ps)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+	require.Equal(t, ps.ChecksumByPrefix().Map(), s.ChecksumByPrefix().Map())
+
+	require.NoError(t, s.Delete(stKey("key")))
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+
+	// Stacked caches.
+	s2 := NewMemCachedStore(s)
+	require.Equal(t, s.Checksum(), s2.Checksum())
+
+	// Different options, the checksum is recalculated.
+	require.NoError(t, ps.Put(AppendPrefixInt(DataBlock, 1), []byte("block")))
+	for _, opts := range [][]Option{{WithHashFunc(SHA3)}, {WithAccumulator(new(LtHashAccumulator))},
+		{WithScope(FullScope)}, {WithPrefixScope(DataBlock)}} {
+		ref := NewMemoryStore(opts...)
+		copyStore(t, ref, ps)
+		s = NewMemCachedStore(ps, opts...)
+		require.Equal(t, ref.Checksum(), s.Checksum())
+		s, err = OpenMemCachedStore(ps, opts...)
+		require.NoError(t, err)
+		require.Equal(t, ref.Checksum(), s.Checksum())
+	}
+
+	// Changes of keys that are out of the lower store scope.
+	s = NewMemCachedStore(ps, WithScope(FullScope))
+	require.NoError(t, s.Delete(AppendPrefixInt(DataBlock, 1)))
+	require.NoError(t, s.Delete(AppendPrefix(STAccount, []byte("acc"))))
+	require.Equal(t, NewMemoryStore().Checksum(), s.Checksum())
+}
+
+func TestCachedChecksumRecord(t *testing.T) {
+	for _, acc := range []Accumulator{new(XorAccumulator), new(MuHashAccumulator)} {
+		ps := NewMemoryStore(WithAccumulator(acc))
+		s, err := OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord())
+		require.NoError(t, err)
+		require.NoError(t, s.Put(stKey("key"), []byte("value")))
+		require.NoError(t, s.Put(AppendPrefix(STCoin, []byte("coin")), []byte("value")))
+		_, err = s.Persist()
+		require.NoError(t, err)
+		_, err = ps.Get(SYSStateChecksum.Bytes())
+		require.NoError(t, err)
+		require.Equal(t, ps.Checksum(), s.Checksum())
+
+		// Record is used after re-attachment.
+		s, err = OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord(), WithVerifyRecord())
+		require.NoError(t, err)
+		require.Equal(t, ps.Checksum(), s.Checksum())
+
+		// Record made for another scope is not used.
+		require.NoError(t, ps.Put(AppendPrefixInt(DataBlock, 1), []byte("block")))
+		s, err = OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord(),
+			WithVerifyRecord(), WithScope(FullScope))
+		require.NoError(t, err)
+		ref := NewMemoryStore(WithAccumulator(acc), WithScope(FullScope))
+		copyStore(t, ref, ps)
+		require.Equal(t, ref.Checksum(), s.Checksum())
+		require.NotEqual(t, ps.Checksum(), s.Checksum())
+
+		// Changing lower store behind cache's back.
+		require.NoError(t, ps.Put(AppendPrefix(STCoin, []byte("coin")), []byte("changed")))
+		_, err = OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord(), WithVerifyRecord())
+		require.True(t, errors.Is(err, ErrChecksumMismatch))
+		require.Contains(t, err.Error(), STCoin.String())
+
+		// Without verification the record is trusted.
+		s, err = OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord())
+		require.NoError(t, err)
+		require.NotEqual(t, ps.Checksum(), s.Checksum())
+
+		// Without record the lower store is used.
+		s, err = OpenMemCachedStore(ps, WithAccumulator(acc))
+		require.NoError(t, err)
+		require.Equal(t, ps.Checksum(), s.Checksum())
+
+		// Broken record.
+		require.NoError(t, ps.Put(SYSStateChecksum.Bytes(), []byte{1, 2, 3}))
+		_, err = OpenMemCachedStore(ps, WithAccumulator(acc), WithChecksumRecord())
+		require.Error(t, err)
+	}
+}
+
+func TestCachedVerifyRecordRecalculates(t *testing.T) {
+	ps := NewBTreeStore()
+	s := NewMemCachedStore(ps, WithChecksumRecord())
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	_, err := s.Persist()
+	require.NoError(t, err)
+
+	// Data changed without updating the checksum tracked by the lower store.
+	ps.tree.set(kvEntry{key: string(stKey("key")), val: []byte("changed")})
+	require.Equal(t, s.Checksum(), ps.Checksum())
+	_, err = OpenMemCachedStore(ps, WithChecksumRecord(), WithVerifyRecord())
+	require.True(t, errors.Is(err, ErrChecksumMismatch))
+
+	// Only data matters, not the checksum tracked by the lower store.
+	ps = NewBTreeStore()
+	s = NewMemCachedStore(ps, WithChecksumRecord())
+	s2 := NewMemCachedStore(s, WithChecksumRecord())
+	require.NoError(t, s2.Put(stKey("key"), []byte("value")))
+	_, err = s2.Persist()
+	require.NoError(t, err)
+	s.prefixSum(string(stKey("key"))).Add(HashKV("bad", nil))
+	s3, err := OpenMemCachedStore(s, WithChecksumRecord(), WithVerifyRecord())
+	require.NoError(t, err)
+	require.Equal(t, s2.Checksum(), s3.Checksum())
+}
+
+func TestCachedPutBatchChecksum(t *testing.T) {
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator)} {
+		ps := NewMemoryStore(WithAccumulator(acc))
+		require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+		require.NoError(t, ps.Put(stKey("lower2"), []byte("v2")))
+		require.NoError(t, ps.Put(stKey("lower3"), []byte("v3")))
+		s := NewMemCachedStore(ps, WithAccumulator(acc))
+		require.NoError(t, s.Put(stKey("cached"), []byte("c")))
+
+		batch := s.Batch()
+		// Overwrite of the lower store value.
+		batch.Put(stKey("lower1"), []byte("new1"))
+		// Deletion of the lower store value.
+		batch.Delete(stKey("lower2"))
+		// Deleted and re-added in the same batch.
+		batch.Delete(stKey("lower3"))
+		batch.Put(stKey("lower3"), []byte("new3"))
+		// Added and deleted in the same batch.
+		batch.Put(stKey("new"), []byte("n"))
+		batch.Delete(stKey("new"))
+		// Overwrite of the cached value.
+		batch.Put(stKey("cached"), []byte("newc"))
+		// Out of scope key.
+		batch.Put(SYSCurrentBlock.Bytes(), []byte{1})
+		require.NoError(t, s.PutBatch(batch))
+
+		v, err := s.Get(stKey("lower3"))
+		require.NoError(t, err)
+		require.Equal(t, []byte("new3"), v)
+		_, err = s.Get(stKey("lower2"))
+		require.Equal(t, ErrKeyNotFound, err)
+
+		ref := NewMemoryStore(WithAccumulator(acc))
+		for k, v := range map[string]string{"lower1": "new1", "lower3": "new3", "cached": "newc"} {
+			require.NoError(t, ref.Put(stKey(k), []byte(v)))
+		}
+		require.Equal(t, ref.Checksum(), s.Checksum())
+		_, err = s.Persist()
+		require.NoError(t, err)
+		require.Equal(t, ref.Checksum(), ps.Checksum())
+		require.Equal(t, ps.Checksum(), s.Checksum())
+	}
+}
+
+// failingStore is a Store which Get fails with err if it's set.
+type failingStore struct {
+	Store
+	err error
+}
+
+func (s *failingStore) Get(key []byte) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Store.Get(key)
+}
+
+func TestCachedLowerError(t *testing.T) {
+	ps := &failingStore{Store: NewMemoryStore()}
+	require.NoError(t, ps.Put(stKey("key"), []byte("value")))
+	s := NewMemCachedStore(ps)
+	sum := s.Checksum()
+
+	readErr := errors.New("read error")
+	ps.err = readErr
+	require.True(t, errors.Is(s.Put(stKey("key"), []byte("new")), readErr))
+	require.True(t, errors.Is(s.Delete(stKey("key")), readErr))
+	b := s.Batch()
+	b.Put(SYSCurrentBlock.Bytes(), []byte{1})
+	b.Put(stKey("new"), []byte("new"))
+	b.Delete(stKey("key"))
+	require.True(t, errors.Is(s.PutBatch(b), readErr))
+	require.Equal(t, 0, s.cache.len)
+	require.Equal(t, sum, s.Checksum())
+
+	// Previous values of keys out of scope are only needed for Changeset.
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	_, err := s.Changeset()
+	require.True(t, errors.Is(err, readErr))
+	require.True(t, errors.Is(s.EncodeChangeset(io.Discard), readErr))
+
+	ps.err = nil
+	require.NoError(t, s.Put(stKey("key"), []byte("new")))
+	require.Equal(t, []Change{
+		{Key: stKey("key"), Value: []byte("new"), Prev: []byte("value")},
+		{Key: SYSCurrentBlock.Bytes(), Value: []byte{1}},
+	}, getChangeset(t, s).Changes)
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+}
+
+func TestCachedDeleteThenPut(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("key"), []byte("value")))
+	s := NewMemCachedStore(ps)
+	require.NoError(t, s.Delete(stKey("key")))
+	require.NoError(t, s.Put(stKey("key"), []byte("newvalue")))
+
+	ref := NewMemoryStore()
+	require.NoError(t, ref.Put(stKey("key"), []byte("newvalue")))
+	require.Equal(t, ref.Checksum(), s.Checksum())
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ref.Checksum(), ps.Checksum())
+	require.Equal(t, ref.Checksum(), s.Checksum())
+}
+
+// TestCachedChecksumModel applies random operations to MemCachedStore and
+// to the reference MemoryStore and checks that the incremental checksum is
+// always the same as the full one.
+func TestCachedChecksumModel(t *testing.T) {
+	var (
+		keys = [][]byte{stKey("a"), stKey("b"), stKey("c"), stKey("d"),
+			AppendPrefix(STAccount, []byte("a")), AppendPrefix(STCoin, []byte("a")),
+			AppendPrefixInt(DataBlock, 1), SYSCurrentBlock.Bytes()}
+		values = [][]byte{{}, []byte("1"), []byte("2"), []byte("3")}
+	)
+	for _, acc := range []Accumulator{new(XorAccumulator), new(LtHashAccumulator)} {
+		var (
+			r   = rand.New(rand.NewSource(42))
+			ps  = NewMemoryStore(WithAccumulator(acc))
+			ref = NewMemoryStore(WithAccumulator(acc))
+		)
+		for _, k := range keys[:4] {
+			require.NoError(t, ps.Put(k, values[1]))
+			require.NoError(t, ref.Put(k, values[1]))
+		}
+		s := NewMemCachedStore(ps, WithAccumulator(acc))
+		for i := 0; i < 2000; i++ {
+			switch op := r.Intn(10); {
+			case op < 4:
+				k, v := keys[r.Intn(len(keys))], values[r.Intn(len(values))]
+				require.NoError(t, s.Put(k, v))
+				require.NoError(t, ref.Put(k, v))
+			case op < 8:
+				k := keys[r.Intn(len(keys))]
+				require.NoError(t, s.Delete(k))
+				require.NoError(t, ref.Delete(k))
+			case op < 9 && r.Intn(4) == 0:
+				s.Discard()
+				ref = NewMemoryStore(WithAccumulator(acc))
+				ps.Seek(nil, func(k, v []byte) {
+					require.NoError(t, ref.Put(k, v))
+				})
+			case op < 9:
+				sb, rb := s.Batch(), ref.Batch()
+				for j := r.Intn(5); j >= 0; j-- {
+					k, v := keys[r.Intn(len(keys))], values[r.Intn(len(values))]
+					if r.Intn(2) == 0 {
+						sb.Put(k, v)
+						rb.Put(k, v)
+					} else {
+						sb.Delete(k)
+						rb.Delete(k)
+					}
+				}
+				require.NoError(t, s.PutBatch(sb))
+				require.NoError(t, ref.PutBatch(rb))
+			default:
+				_, err := s.Persist()
+				require.NoError(t, err)
+				require.Equal(t, ref.Checksum(), ps.Checksum(), "op %d", i)
+			}
+			require.Equal(t, ref.Checksum(), s.Checksum(), "op %d", i)
+		}
+	}
+}
+
+// TestCachedConcurrent is mostly useful with the race detector enabled.
+func TestCachedConcurrent(t *testing.T) {
+	const writers, ops = 4, 300
+	var (
+		ps      = NewMemoryStore()
+		s       = NewMemCachedStore(ps)
+		final   = make([]map[string][]byte, writers)
+		wg      sync.WaitGroup
+		readers = []func(){
+			func() { _, _ = s.Get(stKey("w0-0")) },
+			func() { s.Seek(STStorage.Bytes(), func(k, v []byte) {}) },
+			func() { _ = s.Checksum() },
+			func() { _ = s.ChecksumByPrefix().Total() },
+			func() { _ = s.ChangeChecksum() },
+			func() { _, _ = s.Persist() },
+		}
+	)
+	for _, f := range readers {
+		wg.Add(1)
+		go func(f func()) {
+			defer wg.Done()
+			for i := 0; i < ops; i++ {
+				f()
+			}
+		}(f)
+	}
+	for w := 0; w < writers; w++ {
+		final[w] = make(map[string][]byte)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// assert, because FailNow can't be called from other goroutines.
+			r := rand.New(rand.NewSource(int64(w)))
+			key := func() []byte { return stKey(fmt.Sprintf("w%d-%d", w, r.Intn(10))) }
+			for i := 0; i < ops; i++ {
+				switch r.Intn(3) {
+				case 0:
+					k, v := key(), []byte{byte(i)}
+					assert.NoError(t, s.Put(k, v))
+					final[w][string(k)] = v
+				case 1:
+					k := key()
+					assert.NoError(t, s.Delete(k))
+					delete(final[w], string(k))
+				default:
+					b := s.Batch()
+					k1, k2, v := key(), key(), []byte{byte(i)}
+					b.Put(k1, v)
+					b.Delete(k2)
+					assert.NoError(t, s.PutBatch(b))
+					final[w][string(k1)] = v
+					delete(final[w], string(k2))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	ref := NewMemoryStore()
+	for _, m := range final {
+		for k, v := range m {
+			require.NoError(t, ref.Put([]byte(k), v))
+		}
+	}
+	require.Equal(t, ref.Checksum(), s.Checksum())
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ref.Checksum(), ps.Checksum())
+}
+
+func TestCachedDiscard(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+	require.NoError(t, ps.Put(stKey("lower2"), []byte("v2")))
+	require.NoError(t, ps.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	s := NewMemCachedStore(ps)
+	sum := s.Checksum()
+
+	require.NoError(t, s.Put(stKey("lower1"), []byte("new1")))
+	require.NoError(t, s.Delete(stKey("lower2")))
+	require.NoError(t, s.Put(stKey("lower2"), []byte("new2")))
+	require.NoError(t, s.Put(stKey("new"), []byte("new")))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{2}))
+	require.NoError(t, s.Put(stKey("tmp"), []byte("tmp")))
+	require.NoError(t, s.Delete(stKey("tmp")))
+	require.NotEqual(t, sum, s.Checksum())
+	// Changeset remembers original values of out-of-scope keys too.
+	_ = getChangeset(t, s)
+
+	s.Discard()
+	require.Equal(t, sum, s.Checksum())
+	require.Equal(t, ps.ChecksumByPrefix().Map(), s.ChecksumByPrefix().Map())
+	require.Equal(t, defaultChecksum(make([]byte, len(Uint256{}))), s.ChangeChecksum())
+	for k, v := range map[string][]byte{
+		string(stKey("lower1")):         []byte("v1"),
+		string(stKey("lower2")):         []byte("v2"),
+		string(SYSCurrentBlock.Bytes()): {1},
+	} {
+		val, err := s.Get([]byte(k))
+		require.NoError(t, err)
+		require.Equal(t, v, val)
+	}
+	_, err := s.Get(stKey("new"))
+	require.Equal(t, ErrKeyNotFound, err)
+
+	// Nothing to persist after discard.
+	c, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, 0, c)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+}
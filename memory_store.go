@@ -1,7 +1,7 @@
 package xorkv
 
 import (
-	"strings"
+	"sort"
 	"sync"
 )
 
@@ -12,6 +12,8 @@ type MemoryStore struct {
 	mem map[string][]byte
 	// A map, not a slice, to avoid duplicates.
 	del map[string]bool
+
+	opts options
 }
 
 // MemoryBatch is an in-memory batch compatible with MemoryStore.
@@ -30,10 +32,11 @@ func (b *MemoryBatch) Delete(k []byte) {
 }
 
 // NewMemoryStore creates a new MemoryStore object.
-func NewMemoryStore() *MemoryStore {
+func NewMemoryStore(opts ...Option) *MemoryStore {
 	return &MemoryStore{
-		mem: make(map[string][]byte),
-		del: make(map[string]bool),
+		mem:  make(map[string][]byte),
+		del:  make(map[string]bool),
+		opts: newOptions(opts),
 	}
 }
 
@@ -97,11 +100,27 @@ func (s *MemoryStore) PutBatch(batch Batch) error {
 
 // Seek implements the Store interface.
 func (s *MemoryStore) Seek(key []byte, f func(k, v []byte)) {
+	s.SeekRange(KeyRange{Prefix: key}, f)
+}
+
+// SeekRange implements the Store interface.
+func (s *MemoryStore) SeekRange(r KeyRange, f func(k, v []byte)) {
+	iterate(s.NewIterator(r), f)
+}
+
+// NewIterator implements the Store interface, the iterator works with a
+// snapshot of pairs from the range.
+func (s *MemoryStore) NewIterator(r KeyRange) Iterator {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	var entries []kvEntry
 	for k, v := range s.mem {
-		if strings.HasPrefix(k, string(key)) {
-			f([]byte(k), v)
+		if r.contains(k) {
+			entries = append(entries, kvEntry{key: k, val: v})
 		}
 	}
+	sort.Slice(entries, func(i, j int) bool { return r.less(entries[i].key, entries[j].key) })
+	return &sliceIterator{entries: entries}
 }
 
 // Batch implements the Batch interface and returns a compatible Batch.
@@ -114,15 +133,36 @@ func newMemoryBatch() *MemoryBatch {
 	return &MemoryBatch{MemoryStore: *NewMemoryStore()}
 }
 
-// Checksum returns XORed hashes of all key-value pairs.
-func (s *MemoryStore) Checksum() Uint256 {
-	hash := Uint256{}
+// Checksum returns accumulated hashes of all key-value pairs in scope.
+func (s *MemoryStore) Checksum() Checksum {
+	acc := s.opts.acc.Zero()
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for k, v := range s.mem {
+		if s.opts.inScope([]byte(k)) {
+			acc.Add(s.opts.hashKV(k, v))
+		}
+	}
+	return s.opts.checksum(acc)
+}
+
+// ChecksumByPrefix returns checksums of key-value pairs in scope grouped by
+// KeyPrefix.
+func (s *MemoryStore) ChecksumByPrefix() PrefixChecksums {
+	accs := make(map[KeyPrefix]Accumulator)
 	s.mut.Lock()
 	defer s.mut.Unlock()
 	for k, v := range s.mem {
-		hash.Xor(HashKV(k, v))
+		if !s.opts.inScope([]byte(k)) {
+			continue
+		}
+		p := keyPrefix(k)
+		if _, ok := accs[p]; !ok {
+			accs[p] = s.opts.acc.Zero()
+		}
+		accs[p].Add(s.opts.hashKV(k, v))
 	}
-	return hash
+	return PrefixChecksums{opts: s.opts, accs: accs}
 }
 
 // Close implements Store interface and clears up memory. Never returns an
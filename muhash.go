@@ -0,0 +1,137 @@
+package xorkv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
+)
+
+// muHashSize is the size of MuHash group element in bytes.
+const muHashSize = 384
+
+// muHashPrime is the largest 3072-bit safe prime, 2^3072 - 1103717.
+var muHashPrime = new(big.Int).Sub(
+	new(big.Int).Lsh(big.NewInt(1), muHashSize*8),
+	big.NewInt(1103717))
+
+// MuHashAccumulator is a multiplicative set hash (MuHash3072) Accumulator.
+// Every element is mapped into the multiplicative group modulo 3072-bit safe
+// prime, additions multiply the numerator by it and removals multiply the
+// denominator, so that the inversion is only done once when the digest is
+// needed. The zero value is an empty set ready to use.
+type MuHashAccumulator struct {
+	num *big.Int
+	den *big.Int
+}
+
+// muHashExpand maps the element into the group using SHA-256 in counter mode.
+func muHashExpand(e Uint256) *big.Int {
+	var (
+		data = make([]byte, 0, muHashSize)
+		buf  = make([]byte, len(e)+4)
+	)
+	copy(buf, e[:])
+	for i := 0; len(data) < muHashSize; i++ {
+		binary.LittleEndian.PutUint32(buf[len(e):], uint32(i))
+		block := sha256.Sum256(buf)
+		data = append(data, block[:]...)
+	}
+	return new(big.Int).Mod(new(big.Int).SetBytes(data), muHashPrime)
+}
+
+// init makes the zero value usable.
+func (a *MuHashAccumulator) init() {
+	if a.num == nil {
+		a.num = big.NewInt(1)
+		a.den = big.NewInt(1)
+	}
+}
+
+// muHashMul multiplies x by y modulo muHashPrime in place.
+func muHashMul(x, y *big.Int) {
+	x.Mul(x, y)
+	x.Mod(x, muHashPrime)
+}
+
+// Add implements the Accumulator interface.
+func (a *MuHashAccumulator) Add(e Uint256) {
+	a.init()
+	muHashMul(a.num, muHashExpand(e))
+}
+
+// Remove implements the Accumulator interface.
+func (a *MuHashAccumulator) Remove(e Uint256) {
+	a.init()
+	muHashMul(a.den, muHashExpand(e))
+}
+
+// Combine implements the Accumulator interface.
+func (a *MuHashAccumulator) Combine(o Accumulator) {
+	v := o.(*MuHashAccumulator)
+	if v.num == nil {
+		return
+	}
+	a.init()
+	muHashMul(a.num, v.num)
+	muHashMul(a.den, v.den)
+}
+
+// normalize performs the deferred inversion of the denominator.
+func (a *MuHashAccumulator) normalize() {
+	a.init()
+	if a.den.Cmp(big.NewInt(1)) == 0 {
+		return
+	}
+	muHashMul(a.num, new(big.Int).ModInverse(a.den, muHashPrime))
+	a.den.SetInt64(1)
+}
+
+// Sum implements the Accumulator interface, the digest is a SHA-256 hash of
+// the group element representing the set.
+func (a *MuHashAccumulator) Sum() []byte {
+	a.normalize()
+	h := sha256.Sum256(muHashBytes(a.num))
+	return h[:]
+}
+
+// Zero implements the Accumulator interface.
+func (a *MuHashAccumulator) Zero() Accumulator {
+	return new(MuHashAccumulator)
+}
+
+// muHashBytes returns fixed-size big-endian representation of x.
+func muHashBytes(x *big.Int) []byte {
+	var (
+		res = make([]byte, muHashSize)
+		b   = x.Bytes()
+	)
+	copy(res[muHashSize-len(b):], b)
+	return res
+}
+
+// Kind implements the Accumulator interface.
+func (a *MuHashAccumulator) Kind() AccumulatorKind {
+	return KindMuHash
+}
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. Both
+// numerator and denominator are serialized, so no inversion is done.
+func (a *MuHashAccumulator) MarshalBinary() ([]byte, error) {
+	a.init()
+	return append(muHashBytes(a.num), muHashBytes(a.den)...), nil
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface.
+func (a *MuHashAccumulator) UnmarshalBinary(data []byte) error {
+	if len(data) != 2*muHashSize {
+		return ErrInvalidAccumulatorState
+	}
+	num := new(big.Int).SetBytes(data[:muHashSize])
+	den := new(big.Int).SetBytes(data[muHashSize:])
+	if num.Sign() == 0 || den.Sign() == 0 ||
+		num.Cmp(muHashPrime) >= 0 || den.Cmp(muHashPrime) >= 0 {
+		return ErrInvalidAccumulatorState
+	}
+	a.num, a.den = num, den
+	return nil
+}
@@ -0,0 +1,50 @@
+package xorkv
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMuHashPrime(t *testing.T) {
+	require.Equal(t, muHashSize*8, muHashPrime.BitLen())
+	require.True(t, muHashPrime.ProbablyPrime(4))
+	q := new(big.Int).Rsh(muHashPrime, 1)
+	require.True(t, q.ProbablyPrime(4))
+}
+
+func TestMuHashDeferredInversion(t *testing.T) {
+	var (
+		a   MuHashAccumulator
+		ref MuHashAccumulator
+		els = testElements(8)
+	)
+	for _, e := range els {
+		a.Add(e)
+	}
+	for _, e := range els[4:] {
+		a.Remove(e)
+	}
+	for _, e := range els[:4] {
+		ref.Add(e)
+	}
+	// Removals are only accumulated in the denominator.
+	require.NotEqual(t, 0, a.den.Cmp(big.NewInt(1)))
+	require.Equal(t, ref.Sum(), a.Sum())
+	require.Equal(t, 0, a.den.Cmp(big.NewInt(1)))
+}
+
+func TestMuHashUnmarshalInvalid(t *testing.T) {
+	var a MuHashAccumulator
+	data, err := a.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, a.UnmarshalBinary(data))
+	require.Error(t, a.UnmarshalBinary(make([]byte, 2*muHashSize)))
+	copy(data, muHashBytes(muHashPrime))
+	require.Error(t, a.UnmarshalBinary(data))
+}
+
+func TestMuHashStores(t *testing.T) {
+	testStoresAccumulatorConsistency(t, new(MuHashAccumulator))
+}
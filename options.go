@@ -0,0 +1,173 @@
+package xorkv
+
+import (
+	"bytes"
+)
+
+// Option is a store configuration option, see NewMemoryStore and
+// NewMemCachedStore.
+type Option func(*options)
+
+// options contains store checksum calculation settings.
+type options struct {
+	// acc is an empty accumulator used as a prototype for checksums.
+	acc Accumulator
+	// enc is used to serialize key-value pairs for hashing.
+	enc ElementEncoding
+	// hash is used to hash serialized key-value pairs.
+	hash HashFunc
+	// scope filters keys covered by the checksum.
+	scope Scope
+	// scopeID identifies the scope in checksum records, it's nil for custom
+	// scopes that can't be compared with others (see sameScope).
+	scopeID []byte
+	// record enables persisting MemCachedStore checksum state into the lower
+	// store.
+	record bool
+	// verify enables checking persisted checksum state against the lower
+	// store contents.
+	verify bool
+	// undoRecords enables persisting MemCachedStore undo records into the
+	// lower store.
+	undoRecords bool
+	// history enables recording MemCachedStore checksum history into the
+	// lower store, historyDepth limits the number of blocks it's kept for.
+	history      bool
+	historyDepth uint32
+	// sync enables FileStore log syncing after every write.
+	sync bool
+}
+
+// WithAccumulator sets the accumulator kind to use for checksums, the
+// default is XorAccumulator.
+func WithAccumulator(a Accumulator) Option {
+	return func(o *options) {
+		o.acc = a.Zero()
+	}
+}
+
+// WithElementEncoding sets key-value pair encoding used for hashing, the
+// default is ElementEncodingV1.
+func WithElementEncoding(e ElementEncoding) Option {
+	return func(o *options) {
+		o.enc = e
+	}
+}
+
+// WithHashFunc sets the hash function used to hash key-value pairs, the
+// default is SHA256.
+func WithHashFunc(h HashFunc) Option {
+	return func(o *options) {
+		o.hash = h
+	}
+}
+
+// WithScope sets the checksum Scope, the default is StateScope. Stores can't
+// tell which keys an arbitrary Scope covers, so checksums they get from other
+// stores or checksum records are recalculated for it, WithPrefixScope doesn't
+// have this problem.
+func WithScope(s Scope) Option {
+	return func(o *options) {
+		o.scope = s
+		o.scopeID = nil
+	}
+}
+
+// WithPrefixScope sets the checksum Scope to PrefixScope with the given
+// prefixes, unlike WithScope it's recorded along with the checksum, so
+// checksums calculated for the same prefixes can be reused.
+func WithPrefixScope(prefixes ...KeyPrefix) Option {
+	return func(o *options) {
+		o.scope = PrefixScope(prefixes...)
+		o.scopeID = prefixScopeID(prefixes)
+	}
+}
+
+// WithChecksumRecord makes MemCachedStore persist its checksum state into the
+// lower store with every Persist and OpenMemCachedStore use this record to
+// initialize the checksum instead of recalculating it.
+func WithChecksumRecord() Option {
+	return func(o *options) {
+		o.record = true
+	}
+}
+
+// WithVerifyRecord makes OpenMemCachedStore recalculate the lower store
+// checksum once iterating over all of its data and compare it with the
+// persisted record (see WithChecksumRecord), for OpenFileStore it makes the
+// checksum saved in the log be recalculated from the log data.
+func WithVerifyRecord() Option {
+	return func(o *options) {
+		o.verify = true
+	}
+}
+
+// WithUndoRecords makes MemCachedStore save an undo record (see UndoRecord)
+// into the lower store with every Persist, so that it can later be reverted
+// with Revert.
+func WithUndoRecords() Option {
+	return func(o *options) {
+		o.undoRecords = true
+	}
+}
+
+// WithChecksumHistory makes MemCachedStore record state and change checksums
+// into the lower store with every Persist that updates SYSCurrentBlock (see
+// ChecksumAt). Entries for blocks older than depth are pruned, zero depth
+// keeps all of them.
+func WithChecksumHistory(depth uint32) Option {
+	return func(o *options) {
+		o.history = true
+		o.historyDepth = depth
+	}
+}
+
+// WithSync makes FileStore fsync its log after every write, so that written
+// data survives power loss, not just process crashes.
+func WithSync() Option {
+	return func(o *options) {
+		o.sync = true
+	}
+}
+
+// newOptions returns options with defaults overridden by the given opts.
+func newOptions(opts []Option) options {
+	o := options{
+		acc:     new(XorAccumulator),
+		enc:     ElementEncodingV1,
+		hash:    SHA256,
+		scope:   StateScope,
+		scopeID: prefixScopeID(stateScopePrefixes),
+	}
+	for _, f := range opts {
+		f(&o)
+	}
+	return o
+}
+
+// inScope returns true if the key is covered by checksums, records stores
+// keep for themselves never are.
+func (o *options) inScope(key []byte) bool {
+	return o.scope(key) && !isServiceKey(key)
+}
+
+// isServiceKey returns true for keys of records stores keep for themselves.
+func isServiceKey(key []byte) bool {
+	return bytes.Equal(key, checksumRecordKey) || bytes.HasPrefix(key, undoRecordPrefix) ||
+		bytes.HasPrefix(key, checksumHistoryPrefix)
+}
+
+// hashKV returns checksum element for the given key-value pair.
+func (o *options) hashKV(k string, v []byte) Uint256 {
+	return o.hash.Sum(o.enc.Encode(k, v))
+}
+
+// checksum returns Checksum for the given accumulator.
+func (o *options) checksum(acc Accumulator) Checksum {
+	return Checksum{
+		Accumulator: acc.Kind(),
+		Hash:        o.hash,
+		Encoding:    o.enc,
+		Digest:      acc.Sum(),
+	}
+}
@@ -0,0 +1,113 @@
+package xorkv
+
+import (
+	"errors"
+)
+
+// ErrInvalidSavepoint is returned when rolling back to the savepoint that was
+// released, rolled back over or belongs to already persisted (or discarded)
+// changes.
+var ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+// Savepoint is a token representing MemCachedStore state at some point in
+// time, see MemCachedStore.Savepoint.
+type Savepoint struct {
+	// id is the unique number of the savepoint (starting from 1), so tokens
+	// of savepoints that are no longer active never become valid again.
+	id uint64
+	// depth is the position in the savepoint stack.
+	depth int
+	// pos is the undo log position.
+	pos int
+}
+
+// undoEntry is the cache state of the key before some change.
+type undoEntry struct {
+	key string
+	// e is the cached entry, it's only valid if cached is set.
+	e      kvEntry
+	cached bool
+}
+
+// Savepoint creates a new savepoint, all changes made after it can then be
+// undone with RollbackTo. Savepoints are nested, rolling back to some
+// savepoint invalidates all the savepoints created after it. Changes are
+// logged for as long as there are active savepoints, so savepoints that are
+// no longer needed should be released with Release.
+func (s *MemCachedStore) Savepoint() Savepoint {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.savepointID++
+	sp := Savepoint{id: s.savepointID, depth: len(s.savepoints), pos: len(s.undo)}
+	s.savepoints = append(s.savepoints, sp)
+	return sp
+}
+
+// valid checks whether the savepoint is still active, it's supposed to be
+// called with mutex locked.
+func (s *MemCachedStore) valid(sp Savepoint) bool {
+	return sp.id != 0 && sp.depth < len(s.savepoints) && s.savepoints[sp.depth] == sp
+}
+
+// RollbackTo undoes all Put and Delete operations (with their effect on
+// checksums) made after the given savepoint was created. The savepoint itself
+// remains active.
+func (s *MemCachedStore) RollbackTo(sp Savepoint) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.valid(sp) {
+		return ErrInvalidSavepoint
+	}
+	for i := len(s.undo) - 1; i >= sp.pos; i-- {
+		s.undoChange(s.undo[i])
+	}
+	s.undo = s.undo[:sp.pos]
+	s.savepoints = s.savepoints[:sp.depth+1]
+	return nil
+}
+
+// Release releases the given savepoint and all savepoints created after it,
+// changes made after it are kept.
+func (s *MemCachedStore) Release(sp Savepoint) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.valid(sp) {
+		return ErrInvalidSavepoint
+	}
+	s.savepoints = s.savepoints[:sp.depth]
+	if len(s.savepoints) == 0 {
+		s.undo = nil
+	}
+	return nil
+}
+
+// logChange records the cache state of the key before changing it if there
+// are active savepoints, it's supposed to be called with mutex locked.
+func (s *MemCachedStore) logChange(key string) {
+	if len(s.savepoints) == 0 {
+		return
+	}
+	e, cached := s.cache.get(key)
+	s.undo = append(s.undo, undoEntry{key: key, e: e, cached: cached})
+}
+
+// undoChange restores the cache state of the key updating checksums, it's
+// supposed to be called with mutex locked.
+func (s *MemCachedStore) undoChange(e undoEntry) {
+	var inScope = s.opts.inScope([]byte(e.key))
+	if inScope {
+		if cur := s.currentValue(e.key); cur != nil {
+			s.removeSum(e.key, cur)
+		}
+	}
+	if e.cached {
+		s.cache.set(e.e)
+	} else {
+		s.cache.delete(e.key)
+	}
+	if inScope {
+		if val := s.currentValue(e.key); val != nil {
+			s.addSum(e.key, val)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package xorkv
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cachedState is a MemCachedStore state snapshot used to check rollbacks.
+type cachedState struct {
+	sum    Checksum
+	change Checksum
+	kvs    map[string]string
+}
+
+func getCachedState(s *MemCachedStore) cachedState {
+	st := cachedState{
+		sum:    s.Checksum(),
+		change: s.ChangeChecksum(),
+		kvs:    make(map[string]string),
+	}
+	s.Seek(nil, func(k, v []byte) {
+		st.kvs[string(k)] = string(v)
+	})
+	return st
+}
+
+func TestSavepointNested(t *testing.T) {
+	ps := NewMemoryStore()
+	require.NoError(t, ps.Put(stKey("lower"), []byte("v")))
+	s := NewMemCachedStore(ps)
+	require.NoError(t, s.Put(stKey("a"), []byte("a0")))
+
+	st0 := getCachedState(s)
+	sp0 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("a"), []byte("a1")))
+	require.NoError(t, s.Delete(stKey("lower")))
+
+	st1 := getCachedState(s)
+	sp1 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("lower"), []byte("v1")))
+	require.NoError(t, s.Put(stKey("b"), []byte("b1")))
+	require.NoError(t, s.Delete(stKey("a")))
+	b := s.Batch()
+	b.Put(stKey("c"), []byte("c"))
+	b.Delete(stKey("b"))
+	require.NoError(t, s.PutBatch(b))
+
+	sp2 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("d"), []byte("d")))
+
+	require.NoError(t, s.RollbackTo(sp1))
+	require.Equal(t, st1, getCachedState(s))
+	// Savepoints created after sp1 are no longer valid.
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp2))
+
+	// sp1 is still valid and can be used again.
+	require.NoError(t, s.Put(stKey("e"), []byte("e")))
+	require.NoError(t, s.RollbackTo(sp1))
+	require.Equal(t, st1, getCachedState(s))
+
+	require.NoError(t, s.RollbackTo(sp0))
+	require.Equal(t, st0, getCachedState(s))
+
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp0))
+}
+
+func TestSavepointRelease(t *testing.T) {
+	s := NewMemCachedStore(NewMemoryStore())
+	sp0 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("a"), []byte("a")))
+	sp1 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("b"), []byte("b")))
+	st := getCachedState(s)
+
+	require.NoError(t, s.Release(sp1))
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp1))
+	require.Equal(t, ErrInvalidSavepoint, s.Release(sp1))
+	require.Equal(t, st, getCachedState(s))
+
+	require.NoError(t, s.Release(sp0))
+	require.Nil(t, s.undo)
+	require.NoError(t, s.Put(stKey("c"), []byte("c")))
+	require.Nil(t, s.undo)
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp0))
+
+	// Discard invalidates savepoints too.
+	sp := s.Savepoint()
+	s.Discard()
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp))
+
+	// Stale tokens stay invalid when new savepoints take their place.
+	sp1 = s.Savepoint()
+	require.NoError(t, s.Release(sp1))
+	sp2 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("d"), []byte("d")))
+	st = getCachedState(s)
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp1))
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(sp))
+	require.Equal(t, ErrInvalidSavepoint, s.RollbackTo(Savepoint{}))
+	require.Equal(t, st, getCachedState(s))
+	require.NoError(t, s.RollbackTo(sp2))
+
+	sp3 := s.Savepoint()
+	require.NoError(t, s.RollbackTo(sp2))
+	sp4 := s.Savepoint()
+	require.NoError(t, s.Put(stKey("e"), []byte("e")))
+	require.Equal(t, ErrInvalidSavepoint, s.Release(sp3))
+	require.NoError(t, s.Release(sp4))
+}
+
+func TestSavepointRandom(t *testing.T) {
+	var (
+		r    = rand.New(rand.NewSource(42))
+		keys = [][]byte{stKey("a"), stKey("b"), stKey("c"),
+			AppendPrefix(STAccount, []byte("a")), SYSCurrentBlock.Bytes()}
+		ps = NewMemoryStore(WithAccumulator(new(LtHashAccumulator)))
+	)
+	for _, k := range keys[:2] {
+		require.NoError(t, ps.Put(k, []byte("lower")))
+	}
+	s := NewMemCachedStore(ps, WithAccumulator(new(LtHashAccumulator)))
+	for i := 0; i < 50; i++ {
+		var (
+			sps    []Savepoint
+			states []cachedState
+		)
+		for j := r.Intn(4); j >= 0; j-- {
+			states = append(states, getCachedState(s))
+			sps = append(sps, s.Savepoint())
+			for n := r.Intn(10); n >= 0; n-- {
+				k := keys[r.Intn(len(keys))]
+				if r.Intn(2) == 0 {
+					require.NoError(t, s.Put(k, []byte{byte(r.Intn(3))}))
+				} else {
+					require.NoError(t, s.Delete(k))
+				}
+			}
+		}
+		n := r.Intn(len(sps))
+		require.NoError(t, s.RollbackTo(sps[n]))
+		require.Equal(t, states[n], getCachedState(s))
+		require.NoError(t, s.Release(sps[0]))
+		if r.Intn(3) == 0 {
+			_, err := s.Persist()
+			require.NoError(t, err)
+			require.Equal(t, ps.Checksum(), s.Checksum())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package xorkv
+
+// Scope decides which keys are covered by the state checksum, it returns true
+// for keys that are included.
+type Scope func(key []byte) bool
+
+// stateScopePrefixes are the prefixes included in StateScope.
+var stateScopePrefixes = []KeyPrefix{STAccount, STCoin, STSpentCoin, STValidator,
+	STAsset, STContract, STStorage}
+
+// StateScope is the default Scope including only state (ST*) prefixes, chain
+// data, indexes and system keys are not covered by it.
+var StateScope = PrefixScope(stateScopePrefixes...)
+
+// FullScope is a Scope including all keys.
+func FullScope(key []byte) bool {
+	return true
+}
+
+// PrefixScope returns a Scope including keys with the given prefixes.
+func PrefixScope(prefixes ...KeyPrefix) Scope {
+	var set [256]bool
+	for _, p := range prefixes {
+		set[p] = true
+	}
+	return func(key []byte) bool {
+		return len(key) != 0 && set[key[0]]
+	}
+}
+
+// prefixScopeID returns the identity of PrefixScope with the given prefixes
+// stored in checksum records, it's a bitmap of prefixes.
+func prefixScopeID(prefixes []KeyPrefix) []byte {
+	id := make([]byte, 256/8)
+	for _, p := range prefixes {
+		id[p/8] |= 1 << (p % 8)
+	}
+	return id
+}
@@ -0,0 +1,64 @@
+package xorkv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixScope(t *testing.T) {
+	s := PrefixScope(STStorage, STAccount)
+	require.True(t, s(stKey("key")))
+	require.True(t, s(STAccount.Bytes()))
+	require.False(t, s(AppendPrefix(STCoin, []byte("key"))))
+	require.False(t, s(nil))
+
+	for _, p := range []KeyPrefix{STAccount, STCoin, STSpentCoin, STValidator,
+		STAsset, STContract, STStorage} {
+		require.True(t, StateScope(AppendPrefix(p, []byte{1})))
+	}
+	for _, p := range []KeyPrefix{DataBlock, DataTransaction, IXHeaderHashList,
+		IXValidatorsCount, SYSCurrentBlock, SYSCurrentHeader, SYSVersion} {
+		require.False(t, StateScope(AppendPrefix(p, []byte{1})))
+	}
+	require.True(t, FullScope(nil))
+}
+
+func TestStoresScope(t *testing.T) {
+	var adds, removes int
+	acc := &countingAccumulator{adds: &adds, removes: &removes}
+
+	ps := NewMemoryStore(WithAccumulator(acc))
+	s := NewMemCachedStore(ps, WithAccumulator(acc))
+	empty := s.Checksum()
+
+	// Out of scope keys don't need any hashing.
+	require.NoError(t, s.Put(AppendPrefixInt(DataBlock, 1), []byte("block")))
+	require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+	require.NoError(t, s.Delete(AppendPrefixInt(DataBlock, 1)))
+	require.NoError(t, s.Put(AppendPrefixInt(DataBlock, 2), []byte("block")))
+	require.Equal(t, 0, adds)
+	require.Equal(t, 0, removes)
+	require.Equal(t, empty, s.Checksum())
+	require.Equal(t, empty, s.ChangeChecksum())
+
+	require.NoError(t, s.Put(stKey("key"), []byte("value")))
+	_, err := s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+	require.NotEqual(t, empty, s.Checksum())
+	require.Equal(t, []KeyPrefix{STStorage}, ps.ChecksumByPrefix().Prefixes())
+
+	// Predicate scope.
+	onlyKey := func(k []byte) bool { return string(k) == "key" }
+	ps = NewMemoryStore(WithScope(onlyKey))
+	s = NewMemCachedStore(ps, WithScope(onlyKey))
+	empty = s.Checksum()
+	require.NoError(t, s.Put([]byte("foo"), []byte("bar")))
+	require.Equal(t, empty, s.Checksum())
+	require.NoError(t, s.Put([]byte("key"), []byte("value")))
+	require.NotEqual(t, empty, s.Checksum())
+	_, err = s.Persist()
+	require.NoError(t, err)
+	require.Equal(t, ps.Checksum(), s.Checksum())
+}
@@ -3,6 +3,9 @@ package xorkv
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 )
 
 // KeyPrefix constants.
@@ -20,6 +23,9 @@ const (
 	IXValidatorsCount KeyPrefix = 0x90
 	SYSCurrentBlock   KeyPrefix = 0xc0
 	SYSCurrentHeader  KeyPrefix = 0xc1
+	SYSStateChecksum  KeyPrefix = 0xc2
+	SYSUndoRecord     KeyPrefix = 0xc3
+	SYSStateHistory   KeyPrefix = 0xc4
 	SYSVersion        KeyPrefix = 0xf0
 )
 
@@ -34,7 +40,9 @@ var ErrKeyNotFound = errors.New("key not found")
 
 type (
 	// Store is anything that can persist and retrieve the blockchain.
-	// information.
+	// information. Seek and SeekRange iterate over keys in byte order, they
+	// are implemented with NewIterator (see Iterator), so f can use the
+	// store.
 	Store interface {
 		Batch() Batch
 		Delete(k []byte) error
@@ -42,8 +50,11 @@ type (
 		Put(k, v []byte) error
 		PutBatch(Batch) error
 		Seek(k []byte, f func(k, v []byte))
+		SeekRange(r KeyRange, f func(k, v []byte))
+		NewIterator(r KeyRange) Iterator
 		Close() error
-		Checksum() Uint256
+		Checksum() Checksum
+		ChecksumByPrefix() PrefixChecksums
 	}
 
 	// Batch represents an abstraction on top of batch operations.
@@ -54,6 +65,22 @@ type (
 		Put(k, v []byte)
 	}
 
+	// KeyRange describes the set of keys to iterate over with SeekRange or
+	// Iterator.
+	KeyRange struct {
+		// Prefix all keys should have.
+		Prefix []byte
+		// Start is the first key of the range (inclusive), nil means no
+		// lower bound.
+		Start []byte
+		// End is the key the range ends before (exclusive), nil means no
+		// upper bound.
+		End []byte
+		// Reverse makes keys be iterated in descending order (from the last
+		// key before End to Start).
+		Reverse bool
+	}
+
 	// KeyPrefix is a constant byte added as a prefix for each key
 	// stored.
 	KeyPrefix uint8
@@ -64,6 +91,96 @@ func (k KeyPrefix) Bytes() []byte {
 	return []byte{byte(k)}
 }
 
+// String implements the fmt.Stringer interface, it returns the constant name
+// for known prefixes and hex value for others.
+func (k KeyPrefix) String() string {
+	switch k {
+	case DataBlock:
+		return "DataBlock"
+	case DataTransaction:
+		return "DataTransaction"
+	case STAccount:
+		return "STAccount"
+	case STCoin:
+		return "STCoin"
+	case STSpentCoin:
+		return "STSpentCoin"
+	case STValidator:
+		return "STValidator"
+	case STAsset:
+		return "STAsset"
+	case STContract:
+		return "STContract"
+	case STStorage:
+		return "STStorage"
+	case IXHeaderHashList:
+		return "IXHeaderHashList"
+	case IXValidatorsCount:
+		return "IXValidatorsCount"
+	case SYSCurrentBlock:
+		return "SYSCurrentBlock"
+	case SYSCurrentHeader:
+		return "SYSCurrentHeader"
+	case SYSStateChecksum:
+		return "SYSStateChecksum"
+	case SYSUndoRecord:
+		return "SYSUndoRecord"
+	case SYSStateHistory:
+		return "SYSStateHistory"
+	case SYSVersion:
+		return "SYSVersion"
+	default:
+		return fmt.Sprintf("0x%02x", byte(k))
+	}
+}
+
+// contains reports whether the key is in the range.
+func (r KeyRange) contains(k string) bool {
+	return strings.HasPrefix(k, string(r.Prefix)) &&
+		(r.Start == nil || k >= string(r.Start)) &&
+		(r.End == nil || k < string(r.End))
+}
+
+// lowerBound returns the key all keys of the range are greater than or equal
+// to.
+func (r KeyRange) lowerBound() string {
+	if string(r.Start) > string(r.Prefix) {
+		return string(r.Start)
+	}
+	return string(r.Prefix)
+}
+
+// upperBound returns the key all keys of the range are less than, false is
+// returned if there is no such key.
+func (r KeyRange) upperBound() (string, bool) {
+	end, bounded := string(r.End), r.End != nil
+	// The first key after all keys with the prefix.
+	for i := len(r.Prefix) - 1; i >= 0; i-- {
+		if r.Prefix[i] != 0xff {
+			p := []byte(string(r.Prefix[:i+1]))
+			p[i]++
+			if !bounded || string(p) < end {
+				end, bounded = string(p), true
+			}
+			break
+		}
+	}
+	return end, bounded
+}
+
+// less reports whether key a goes before key b in the range iteration order.
+func (r KeyRange) less(a, b string) bool {
+	if r.Reverse {
+		return a > b
+	}
+	return a < b
+}
+
+// sort sorts keys in the range iteration order.
+func (r KeyRange) sort(keys []string) {
+	sort.Slice(keys, func(i, j int) bool { return r.less(keys[i], keys[j]) })
+}
+
 // AppendPrefix appends byteslice b to the given KeyPrefix.
 // AppendKeyPrefix(SYSVersion, []byte{0x00, 0x01})
 func AppendPrefix(k KeyPrefix, b []byte) []byte {
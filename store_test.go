@@ -0,0 +1,29 @@
+package xorkv
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPrefixString(t *testing.T) {
+	require.Equal(t, "STStorage", STStorage.String())
+	require.Equal(t, "SYSStateChecksum", SYSStateChecksum.String())
+	require.Equal(t, "0x0f", KeyPrefix(0x0f).String())
+}
+
+func TestKeyRangeBounds(t *testing.T) {
+	require.Equal(t, "b", KeyRange{Prefix: []byte("a"), Start: []byte("b")}.lowerBound())
+	require.Equal(t, "ab", KeyRange{Prefix: []byte("ab"), Start: []byte("a")}.lowerBound())
+	_, ok := KeyRange{}.upperBound()
+	require.False(t, ok)
+	_, ok = KeyRange{Prefix: []byte{0xff, 0xff}}.upperBound()
+	require.False(t, ok)
+	end, ok := KeyRange{Prefix: []byte{0x01, 0xff}}.upperBound()
+	require.True(t, ok)
+	require.Equal(t, "\x02", end)
+	end, _ = KeyRange{Prefix: []byte("a"), End: []byte("ab")}.upperBound()
+	require.Equal(t, "ab", end)
+	end, _ = KeyRange{Prefix: []byte("a"), End: []byte("c")}.upperBound()
+	require.Equal(t, "b", end)
+}
@@ -16,6 +16,18 @@ type kvSeen struct {
 	seen bool
 }
 
+// defaultChecksum returns Checksum with the given digest calculated using
+// default options.
+func defaultChecksum(d []byte) Checksum {
+	return Checksum{Encoding: ElementEncodingV1, Digest: d}
+}
+
+// stKey returns contract storage key (that is covered by the default checksum
+// scope) made of the given string.
+func stKey(s string) []byte {
+	return AppendPrefix(STStorage, []byte(s))
+}
+
 type dbSetup struct {
 	name   string
 	create func(*testing.T) Store
@@ -121,6 +133,40 @@ func testStoreSeek(t *testing.T, s Store) {
 	require.NoError(t, s.Close())
 }
 
+// seekRangeKeys returns keys SeekRange yields for the range.
+func seekRangeKeys(s Store, r KeyRange) []string {
+	var keys []string
+	s.SeekRange(r, func(k, _ []byte) {
+		keys = append(keys, string(k))
+	})
+	return keys
+}
+
+func testStoreSeekRange(t *testing.T, s Store) {
+	for _, k := range []string{"fz", "a", "fa", "f", "fb\x00", "fb", "g", "fc"} {
+		require.NoError(t, s.Put([]byte(k), []byte("v"+k)))
+	}
+	var (
+		all  = []string{"f", "fa", "fb", "fb\x00", "fc", "fz"}
+		back = []string{"fz", "fc", "fb\x00", "fb", "fa", "f"}
+	)
+	require.Equal(t, all, seekRangeKeys(s, KeyRange{Prefix: []byte("f")}))
+	require.Equal(t, back, seekRangeKeys(s, KeyRange{Prefix: []byte("f"), Reverse: true}))
+	require.Equal(t, all[2:4], seekRangeKeys(s, KeyRange{Prefix: []byte("f"),
+		Start: []byte("fb"), End: []byte("fc")}))
+	require.Equal(t, back[2:4], seekRangeKeys(s, KeyRange{Prefix: []byte("f"),
+		Start: []byte("fb"), End: []byte("fc"), Reverse: true}))
+	require.Equal(t, all[3:], seekRangeKeys(s, KeyRange{Prefix: []byte("f"), Start: []byte("fb\x00")}))
+	require.Equal(t, []string{"a", "f"}, seekRangeKeys(s, KeyRange{End: []byte("fa")}))
+	require.Empty(t, seekRangeKeys(s, KeyRange{Start: []byte("fc"), End: []byte("fb")}))
+	require.Equal(t, append([]string{"a"}, append(all, "g")...), seekRangeKeys(s, KeyRange{}))
+
+	s.SeekRange(KeyRange{Prefix: []byte("fa")}, func(k, v []byte) {
+		require.Equal(t, "vfa", string(v))
+	})
+	require.NoError(t, s.Close())
+}
+
 func testStoreDeleteNonExistent(t *testing.T, s Store) {
 	key := []byte("sparse")
 
@@ -219,32 +265,35 @@ func testStorePutBatchWithDelete(t *testing.T, s Store) {
 
 func testStoreChecksum(t *testing.T, s Store) {
 	h0 := Uint256{}
-	kv1 := [][]byte{[]byte("key"), []byte("value")}
-	kv2 := [][]byte{[]byte("foo"), []byte("bar")}
+	kv1 := [][]byte{stKey("key"), []byte("value")}
+	kv2 := [][]byte{stKey("foo"), []byte("bar")}
 	hkv1 := HashKV(string(kv1[0]), kv1[1])
 	hkv2 := HashKV(string(kv2[0]), kv2[1])
 	hkv12 := Uint256{}
 	hkv12.Xor(hkv1)
 	hkv12.Xor(hkv2)
 
-	require.Equal(t, h0, s.Checksum())
+	require.Equal(t, defaultChecksum(h0[:]), s.Checksum())
 	require.NoError(t, s.Put(kv1[0], kv1[1]))
-	require.Equal(t, hkv1, s.Checksum())
+	require.Equal(t, defaultChecksum(hkv1[:]), s.Checksum())
 	require.NoError(t, s.Put(kv2[0], kv2[1]))
-	require.Equal(t, hkv12, s.Checksum())
+	require.Equal(t, defaultChecksum(hkv12[:]), s.Checksum())
 	require.NoError(t, s.Delete(kv1[0]))
-	require.Equal(t, hkv2, s.Checksum())
+	require.Equal(t, defaultChecksum(hkv2[:]), s.Checksum())
 	require.NoError(t, s.Delete(kv2[0]))
-	require.Equal(t, h0, s.Checksum())
+	require.Equal(t, defaultChecksum(h0[:]), s.Checksum())
 }
 
 func TestAllDBs(t *testing.T) {
 	var DBs = []dbSetup{
 		{"MemCached", newMemCachedStoreForTesting},
 		{"Memory", newMemoryStoreForTesting},
+		{"File", newFileStoreForTesting},
+		{"BTree", newBTreeStoreForTesting},
 	}
 	var tests = []dbTestFunction{testStoreClose, testStorePutAndGet,
-		testStoreGetNonExistent, testStorePutBatch, testStoreSeek,
+		testStoreGetNonExistent, testStorePutBatch, testStoreSeek, testStoreSeekRange,
+		testStoreIterator,
 		testStoreDeleteNonExistent, testStorePutAndDelete,
 		testStorePutBatchWithDelete, testStoreChecksum}
 	for _, db := range DBs {
@@ -23,7 +23,14 @@ func (u *Uint256) Equals(o Uint256) bool {
 	return true
 }
 
-// HashKV returns Uint256 with a hash of given key and value.
+// HashKV returns Uint256 with a hash of given key and value using
+// ElementEncodingV1.
 func HashKV(k string, v []byte) Uint256 {
-	return sha256.Sum256(append([]byte(k), v...))
+	return ElementEncodingV1.HashKV(k, v)
+}
+
+// LegacyHashKV returns Uint256 with a hash of given key and value using
+// ElementEncodingLegacy.
+func LegacyHashKV(k string, v []byte) Uint256 {
+	return ElementEncodingLegacy.HashKV(k, v)
 }
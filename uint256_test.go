@@ -33,5 +33,7 @@ func TestUint256Xor(t *testing.T) {
 
 func TestHashKV(t *testing.T) {
 	ref := Uint256(sha256.Sum256([]byte("kv")))
+	require.Equal(t, ref, LegacyHashKV("k", []byte("v")))
+	ref = sha256.Sum256([]byte("xorkv/kv\x01\x01k\x01v"))
 	require.Equal(t, ref, HashKV("k", []byte("v")))
 }
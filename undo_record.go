@@ -0,0 +1,198 @@
+package xorkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrInvalidUndoRecord is returned when undo record can't be applied to the
+// store.
+var ErrInvalidUndoRecord = errors.New("invalid undo record")
+
+// undoRecordPrefix is the prefix of undo record keys, they're never covered
+// by checksums.
+var undoRecordPrefix = SYSUndoRecord.Bytes()
+
+// UndoRecord contains everything needed to revert a single MemCachedStore
+// Persist (see WithUndoRecords).
+type UndoRecord struct {
+	// Seq is the sequence number of the record, records are numbered starting
+	// from 1 for every persist that had some changes.
+	Seq uint64
+	// Header describes checksums before (Base) and after (Result) the persist.
+	Header ChangesetHeader
+	// Changes are the persisted changes, every one of them has the previous
+	// value (or nil if the key didn't exist).
+	Changes Changeset
+}
+
+// undoRecordKey returns the key of undo record with the given sequence number.
+func undoRecordKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(SYSUndoRecord.Bytes(), seq)
+}
+
+// lastUndoRecord returns the sequence number of the last undo record in the
+// lower store (0 if there are none), it's supposed to be called with mutex
+// locked.
+func (s *MemCachedStore) lastUndoRecord() uint64 {
+	if !s.undoSeqKnown {
+		s.ps.Seek(undoRecordPrefix, func(k, _ []byte) {
+			if len(k) == len(undoRecordPrefix)+8 {
+				if seq := binary.BigEndian.Uint64(k[len(undoRecordPrefix):]); seq > s.undoSeq {
+					s.undoSeq = seq
+				}
+			}
+		})
+		s.undoSeqKnown = true
+	}
+	return s.undoSeq
+}
+
+// undoRecord returns the serialized undo record for the pending changes, it's
+// supposed to be called with mutex locked.
+func (s *MemCachedStore) undoRecord() ([]byte, error) {
+	cs, err := s.changeset()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := encodeChangeset(buf, s.changesetHeader(cs), cs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// LastUndoRecord returns the last undo record saved into the lower store or
+// ErrKeyNotFound if there are none.
+func (s *MemCachedStore) LastUndoRecord() (UndoRecord, error) {
+	s.mut.Lock()
+	seq := s.lastUndoRecord()
+	s.mut.Unlock()
+	if seq == 0 {
+		return UndoRecord{}, ErrKeyNotFound
+	}
+	return s.GetUndoRecord(seq)
+}
+
+// GetUndoRecord returns undo record with the given sequence number from the
+// lower store.
+func (s *MemCachedStore) GetUndoRecord(seq uint64) (UndoRecord, error) {
+	u := UndoRecord{Seq: seq}
+	data, err := s.ps.Get(undoRecordKey(seq))
+	if err != nil {
+		return u, err
+	}
+	d, err := newDecoder(bytes.NewReader(data), s.opts)
+	if err != nil {
+		return u, err
+	}
+	u.Header = d.Header()
+	for {
+		ch, err := d.Decode()
+		if err == io.EOF {
+			return u, nil
+		}
+		if err != nil {
+			return u, err
+		}
+		u.Changes.Changes = append(u.Changes.Changes, ch)
+	}
+}
+
+// Revert applies the undo record to the lower store restoring all values and
+// the checksum to the state they had before the corresponding Persist, the
+// record itself is deleted along with the checksum history entry of the
+// reverted block (history entries pruned by the Persist are not restored).
+// Only the last undo record can be reverted and there must be no pending
+// changes in the cache.
+func (s *MemCachedStore) Revert(u UndoRecord) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.cache.len != 0 {
+		return fmt.Errorf("%w: cache has pending changes", ErrInvalidUndoRecord)
+	}
+	if last := s.lastUndoRecord(); u.Seq == 0 || u.Seq != last {
+		return fmt.Errorf("%w: record %d is not the last one (%d)", ErrInvalidUndoRecord,
+			u.Seq, last)
+	}
+	if cur := s.opts.checksum(s.stateSum()); !cur.Equals(u.Header.Result) {
+		return fmt.Errorf("%w: store has %s, record expects %s", ErrChecksumMismatch,
+			cur, u.Header.Result)
+	}
+	batch := s.ps.Batch()
+	for _, ch := range u.Changes.Changes {
+		val, err := s.ps.Get(ch.Key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
+		if err == ErrKeyNotFound {
+			val = nil
+		} else if val == nil {
+			val = []byte{}
+		}
+		if !bytes.Equal(val, ch.Value) || (val == nil) != ch.IsDelete() {
+			return fmt.Errorf("%w: key %x was changed after the record was made",
+				ErrInvalidUndoRecord, ch.Key)
+		}
+		if ch.Prev == nil {
+			batch.Delete(ch.Key)
+		} else {
+			batch.Put(ch.Key, ch.Prev)
+		}
+		// Checksum history entry of the reverted block is no longer valid.
+		if bytes.Equal(ch.Key, currentBlockKey) && !ch.IsDelete() {
+			if h, err := blockHeight(ch.Value); err == nil {
+				batch.Delete(historyKey(h))
+			}
+		}
+	}
+	batch.Delete(undoRecordKey(u.Seq))
+
+	s.revertSums(u.Changes, false)
+	if base := s.opts.checksum(s.stateSum()); !base.Equals(u.Header.Base) {
+		s.revertSums(u.Changes, true)
+		return fmt.Errorf("%w: reverted store has %s, record expects %s", ErrChecksumMismatch,
+			base, u.Header.Base)
+	}
+	err := func() error {
+		if s.opts.record {
+			record, err := encodePrefixChecksums(s.checksumByPrefix())
+			if err != nil {
+				return err
+			}
+			batch.Put(checksumRecordKey, record)
+		}
+		return s.ps.PutBatch(batch)
+	}()
+	if err != nil {
+		s.revertSums(u.Changes, true)
+		return err
+	}
+	s.undoSeq--
+	s.reset()
+	return nil
+}
+
+// revertSums updates state checksum reverting the changes (or reapplying them
+// if redo is set), it's supposed to be called with mutex locked.
+func (s *MemCachedStore) revertSums(cs Changeset, redo bool) {
+	for _, ch := range cs.Changes {
+		if !s.opts.inScope(ch.Key) {
+			continue
+		}
+		k := string(ch.Key)
+		from, to := ch.Value, ch.Prev
+		if redo {
+			from, to = to, from
+		}
+		if from != nil {
+			s.removeSum(k, from)
+		}
+		if to != nil {
+			s.addSum(k, to)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package xorkv
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// storeContents returns all key-value pairs of the store.
+func storeContents(s Store) map[string]string {
+	res := make(map[string]string)
+	s.Seek(nil, func(k, v []byte) {
+		res[string(k)] = string(v)
+	})
+	return res
+}
+
+func TestUndoRecordRevert(t *testing.T) {
+	opts := []Option{WithUndoRecords(), WithChecksumRecord(), WithScope(FullScope),
+		WithAccumulator(new(MuHashAccumulator))}
+	ps := NewMemoryStore(opts...)
+	require.NoError(t, ps.Put(stKey("lower1"), []byte("v1")))
+	require.NoError(t, ps.Put(stKey("lower2"), []byte("v2")))
+	require.NoError(t, ps.Put(SYSCurrentBlock.Bytes(), []byte{0}))
+	s := NewMemCachedStore(ps, opts...)
+	_, err := s.LastUndoRecord()
+	require.Equal(t, ErrKeyNotFound, err)
+
+	// Nothing to persist, no record.
+	_, err = s.Persist()
+	require.NoError(t, err)
+	_, err = s.LastUndoRecord()
+	require.Equal(t, ErrKeyNotFound, err)
+
+	states := []map[string]string{storeContents(ps)}
+	sums := []Checksum{s.Checksum()}
+	blocks := []func(){
+		func() {
+			require.NoError(t, s.Put(stKey("lower1"), []byte("new1")))
+			require.NoError(t, s.Delete(stKey("lower2")))
+			require.NoError(t, s.Put(stKey("new"), []byte{}))
+			require.NoError(t, s.Delete(stKey("absent")))
+			require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{1}))
+		},
+		func() {
+			require.NoError(t, s.Put(stKey("lower1"), []byte("newer1")))
+			require.NoError(t, s.Put(stKey("lower2"), []byte("v2")))
+			require.NoError(t, s.Delete(stKey("new")))
+			require.NoError(t, s.Put(SYSCurrentBlock.Bytes(), []byte{2}))
+		},
+	}
+	for _, b := range blocks {
+		b()
+		_, err := s.Persist()
+		require.NoError(t, err)
+		require.Equal(t, ps.Checksum(), s.Checksum())
+		states = append(states, storeContents(ps))
+		sums = append(sums, s.Checksum())
+	}
+
+	for i := len(blocks); i > 0; i-- {
+		u, err := s.LastUndoRecord()
+		require.NoError(t, err)
+		require.Equal(t, uint64(i), u.Seq)
+		require.Equal(t, sums[i], u.Header.Result)
+		require.Equal(t, sums[i-1], u.Header.Base)
+		require.NoError(t, s.Revert(u))
+		require.Equal(t, sums[i-1], s.Checksum())
+		require.Equal(t, sums[i-1], ps.Checksum())
+
+		contents := storeContents(ps)
+		if i == 1 {
+			// There was no checksum record initially.
+			delete(contents, string(checksumRecordKey))
+		}
+		require.Equal(t, states[i-1], contents)
+	}
+	_, err = s.LastUndoRecord()
+	require.Equal(t, ErrKeyNotFound, err)
+
+	// Persisted records are used by reopened stores.
+	for _, b := range blocks {
+		b()
+		_, err := s.Persist()
+		require.NoError(t, err)
+	}
+	s2, err := OpenMemCachedStore(ps, opts...)
+	require.NoError(t, err)
+	u, err := s2.LastUndoRecord()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), u.Seq)
+	require.NoError(t, s2.Revert(u))
+	require.Equal(t, sums[1], ps.Checksum())
+	require.NoError(t, s2.Put(stKey("other"), []byte{}))
+	_, err = s2.Persist()
+	require.NoError(t, err)
+	u, err = s2.LastUndoRecord()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), u.Seq)
+}
+
+func TestUndoRecordRevertErrors(t *testing.T) {
+	opts := []Option{WithUndoRecords()}
+	ps := NewMemoryStore(opts...)
+	s := NewMemCachedStore(ps, opts...)
+	for _, v := range []string{"v1", "v2"} {
+		require.NoError(t, s.Put(stKey("key"), []byte(v)))
+		_, err := s.Persist()
+		require.NoError(t, err)
+	}
+	first, err := s.GetUndoRecord(1)
+	require.NoError(t, err)
+	last, err := s.LastUndoRecord()
+	require.NoError(t, err)
+	_, err = s.GetUndoRecord(3)
+	require.Equal(t, ErrKeyNotFound, err)
+
+	// Not the last one.
+	require.True(t, errors.Is(s.Revert(first), ErrInvalidUndoRecord))
+
+	// Pending changes.
+	require.NoError(t, s.Put(stKey("other"), []byte{}))
+	require.True(t, errors.Is(s.Revert(last), ErrInvalidUndoRecord))
+	s.Discard()
+
+	// Lower store changed behind the cache.
+	require.NoError(t, ps.Put(stKey("key"), []byte("v3")))
+	require.True(t, errors.Is(s.Revert(last), ErrInvalidUndoRecord))
+	require.NoError(t, ps.Put(stKey("key"), []byte("v2")))
+
+	require.NoError(t, s.Revert(last))
+	require.True(t, errors.Is(s.Revert(last), ErrInvalidUndoRecord))
+	require.NoError(t, s.Revert(first))
+	require.Equal(t, 0, len(storeContents(ps)))
+	require.Equal(t, ps.Checksum(), s.Checksum())
+
+	// Changes that can't be reverted are not persisted.
+	require.NoError(t, s.Put(stKey("key"), make([]byte, maxChangeFieldLen+1)))
+	_, err = s.Persist()
+	require.True(t, errors.Is(err, ErrInvalidChangeset))
+	require.Equal(t, 0, len(storeContents(ps)))
+	require.Equal(t, 1, len(getChangeset(t, s).Changes))
+}